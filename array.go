@@ -39,6 +39,12 @@ func (a *array) get(idx int) Comparable {
 }
 
 func (a *array) shouldBe(item Comparable) (pos int, exactly bool) {
+	return a.shouldBeFunc(item, func(x, y Comparable) int {
+		return x.Compare(y)
+	})
+}
+
+func (a *array) shouldBeFunc(item Comparable, compare func(x, y Comparable) int) (pos int, exactly bool) {
 	if len(*a) == 0 {
 		pos = 0
 		return
@@ -47,7 +53,7 @@ func (a *array) shouldBe(item Comparable) (pos int, exactly bool) {
 	var right int = len(*a) - 1
 	for left <= right {
 		mid := left + (right-left)/2
-		r := (*a)[mid].Compare(item)
+		r := compare((*a)[mid], item)
 		if r == 0 {
 			pos = mid
 			exactly = true
@@ -0,0 +1,150 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WriteBatch collects puts and deletes which are applied together by
+// btree.Write
+type WriteBatch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	data Storeable
+	del  bool
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (b *WriteBatch) Put(data Storeable) *WriteBatch {
+	b.ops = append(b.ops, batchOp{data: data})
+	return b
+}
+
+func (b *WriteBatch) Del(data Storeable) *WriteBatch {
+	b.ops = append(b.ops, batchOp{data: data, del: true})
+	return b
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Write applies the operations of b under one lock acquisition. The result
+// of the ith operation is the ith error returned, deleting a missing key
+// results ErrNotFound and doesn't fail the batch. If any other error occurs,
+// the applied operations are reverted and the error is returned. A tree with
+// a store is synced once after all operations are applied, the value chains
+// replaced by the batch are erased after that. The writes are published
+// and kept as versions once the batch commits
+func (tree *btree) Write(b *WriteBatch) ([]error, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.batching = true
+	defer func() {
+		tree.batching = false
+		tree.pending = nil
+		tree.changes = nil
+	}()
+	results := make([]error, len(b.ops))
+	undo := make([]func() error, 0, len(b.ops))
+	restored := map[int]bool{}
+	for i, op := range b.ops {
+		old, err := tree.get(op.data)
+		existed := err == nil
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return results, tree.revert(undo, restored, err)
+		}
+		var stored Storeable
+		if existed && tree.root != nil {
+			// the old value is restored with its chain, which is kept until
+			// the batch is committed
+			stored, _ = tree.root.get(op.data)
+		}
+		if op.del {
+			if results[i] = tree.del(op.data); errors.Is(results[i], ErrNotFound) {
+				continue
+			}
+		} else {
+			results[i] = tree.put(op.data)
+		}
+		if results[i] != nil {
+			return results, tree.revert(undo, restored, results[i])
+		}
+		data := op.data
+		undo = append(undo, func() error {
+			if !existed {
+				return tree.del(data)
+			}
+			if p, ok := stored.(pair); ok {
+				restored[p.ref] = true
+				return tree.insert(stored, old)
+			}
+			return tree.put(old)
+		})
+	}
+	if tree.store != nil {
+		if _, err := tree.sync(); err != nil {
+			return results, tree.revert(undo, restored, err)
+		}
+	}
+	pending, changes := tree.pending, tree.changes
+	tree.batching, tree.pending, tree.changes = false, nil, nil
+	for _, c := range changes {
+		if err := tree.version(c.data, c.del); err != nil {
+			return results, err
+		}
+	}
+	if tree.store == nil {
+		return results, nil
+	}
+	for _, ref := range pending {
+		if err := tree.release(pair{ref: ref}); err != nil {
+			return results, err
+		}
+	}
+	if f, ok := tree.store.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// revert undoes the applied operations of a batch, then erases the value
+// chains written by them, the chains of restored values are kept
+func (tree *btree) revert(undo []func() error, restored map[int]bool, cause error) error {
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			return fmt.Errorf("%w, revert failed: %s", cause, err.Error())
+		}
+	}
+	for _, ref := range tree.pending {
+		if restored[ref] {
+			continue
+		}
+		if err := eraseChain(tree.store, ref); err != nil {
+			return fmt.Errorf("%w, revert failed: %s", cause, err.Error())
+		}
+	}
+	return cause
+}
+
+type flusher interface {
+	Flush() error
+}
+
+// Flush commits the written data of the store to stable storage if rws
+// supports it, like *os.File
+func (s *blockStore) Flush() error {
+	return s.ensure(func() error {
+		if f, ok := s.rws.(interface{ Sync() error }); ok {
+			return f.Sync()
+		}
+		return nil
+	})
+}
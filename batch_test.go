@@ -0,0 +1,167 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTree_Write(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 0)
+			tree.Put(SP("a", "a"))
+			b := NewWriteBatch()
+			for i := 0; i < 20; i++ {
+				k := fmt.Sprintf("%02d", i)
+				b.Put(SP(k, k))
+			}
+			b.Del(SK("a")).Del(SK("missing")).Put(SP("00", "zero"))
+			results, err := tree.Write(b)
+			if err != nil {
+				t.Fatalf("write batch error: %s", err.Error())
+			}
+			if len(results) != b.Len() || !errors.Is(results[21], ErrNotFound) {
+				t.Fatalf("per operation results error")
+			}
+			for i, r := range results {
+				if i != 21 && r != nil {
+					t.Fatalf("operation %d error: %s", i, r.Error())
+				}
+			}
+			if tree.meta == 0 {
+				t.Fatalf("tree with store should be synced by batch")
+			}
+			loaded, err := OpenTree(s, tree.meta)
+			if err != nil {
+				t.Fatalf("open tree error: %s", err.Error())
+			}
+			if val, err := loaded.Get(SK("00")); err != nil || string(val.(pair).Val) != "zero" {
+				t.Fatalf("batch should be applied in order")
+			}
+			if _, err := loaded.Get(SK("a")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("deleted key should not be synced")
+			}
+		})
+	})
+}
+
+func TestTree_WriteRevert(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 0)
+			tree.EnableVersions()
+			tree.EnableChangeLog(10)
+			tree.Put(SP("a", "a"))
+			s.readonly = true
+			b := NewWriteBatch().Put(SP("a", "b")).Put(SP("c", "c")).Put(BP([]byte("big"), make([]byte, 100)))
+			if _, err := tree.Write(b); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("batch should fail when store fails")
+			}
+			if val, _ := tree.Get(SK("a")); string(val.(pair).Val) != "a" {
+				t.Fatalf("failed batch should be reverted")
+			}
+			if _, err := tree.Get(SK("c")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("failed batch should be reverted")
+			}
+			if tree.Seq() != 1 || len(tree.feed.log) != 1 {
+				t.Fatalf("failed batch should publish nothing, seq %d, %d events", tree.Seq(), len(tree.feed.log))
+			}
+			for seq := uint64(1); seq < 8; seq++ {
+				if val, err := tree.GetAt(SK("a"), seq); err != nil || string(val.(pair).Val) != "a" {
+					t.Fatalf("failed batch should leave no versions")
+				}
+			}
+			s.readonly = false
+			if _, err := tree.Write(NewWriteBatch().Put(SP("a", "b")).Put(SP("c", "c"))); err != nil {
+				t.Fatalf("write batch error: %s", err.Error())
+			}
+			if tree.Seq() != 3 || len(tree.feed.log) != 3 || tree.feed.log[2].Seq != 3 || string(tree.feed.log[2].Key) != "c" {
+				t.Fatalf("committed batch should be published in order")
+			}
+			if val, err := tree.GetAt(SK("a"), 2); err != nil || string(val.(pair).Val) != "b" {
+				t.Fatalf("committed batch should be versioned")
+			}
+		})
+	})
+}
+
+func TestTree_WriteRevertChains(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			long := func(c string) []byte {
+				return bytes.Repeat([]byte(c), 50)
+			}
+			tree.Put(BP([]byte("a"), long("a")))
+			tree.Put(BP([]byte("b"), long("b")))
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, _ := s.Stats()
+			b := NewWriteBatch().Put(BP([]byte("a"), long("x"))).Del(SK("b")).Put(BP([]byte("c"), long("c")))
+			b.Put(BP(bytes.Repeat([]byte("k"), 100), nil))
+			if _, err := tree.Write(b); !errors.Is(err, ErrValueTooLarge) {
+				t.Fatalf("batch should fail with a too large key, got %v", err)
+			}
+			for _, k := range []string{"a", "b"} {
+				if val, err := tree.Get(SK(k)); err != nil || !bytes.Equal(val.(pair).Val, long(k)) {
+					t.Fatalf("value of %s should be restored", k)
+				}
+			}
+			if after, _ := s.Stats(); after.Used != before.Used {
+				t.Fatalf("reverted batch should keep %d blocks used, got %d", before.Used, after.Used)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{"a", "b"} {
+				if val, err := loaded.Get(SK(k)); err != nil || !bytes.Equal(val.(pair).Val, long(k)) {
+					t.Fatalf("synced value of %s should be kept", k)
+				}
+			}
+		})
+	})
+}
+
+func TestTree_SyncFailed(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 0)
+			for _, item := range sortedItems(20) {
+				tree.Put(item)
+			}
+			if _, err := tree.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			blocks := append([]int{}, tree.blocks...)
+			tree.Put(SP("new", "new"))
+			s.readonly = true
+			if _, err := tree.Sync(); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("sync should fail when store fails")
+			}
+			if fmt.Sprint(tree.blocks) != fmt.Sprint(blocks) {
+				t.Fatalf("failed sync should keep the pages of the tree in store")
+			}
+			s.readonly = false
+			before, _ := s.Stats()
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after, _ := s.Stats(); after.Used != before.Used+1 {
+				t.Fatalf("sync should erase the old pages, used %d before, %d after", before.Used, after.Used)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Len() != 21 {
+				t.Fatalf("synced tree should have 21 keys, got %d", loaded.Len())
+			}
+		})
+	})
+}
@@ -49,9 +49,51 @@ var (
 			return make([]byte, magicSize+metaSize)
 		},
 	}
-	ErrRWSCNotExists = errors.New("rwsc not exists")
+	ErrRWSCNotExists      = errors.New("rwsc not exists")
+	ErrReadOnly           = errors.New("block store is read only")
+	ErrNotReadOnly        = errors.New("rwsc is not read only")
+	ErrNotFound           = errors.New("not found")
+	ErrExists             = errors.New("rwsc exists")
+	ErrCorrupt            = errors.New("corrupt data")
+	ErrBadFreeList        = errors.New("bad free list")
+	ErrValueTooLarge      = errors.New("value too large")
+	ErrInvalidBlock       = errors.New("invalid block")
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrNotPrepared        = errors.New("you should call Open or Create before any operation")
 )
 
+// PageError records the page an error occurred on, the underlying error is
+// one of the sentinel errors above and can be checked with errors.Is
+type PageError struct {
+	Index int
+	Err   error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("page %d: %s", e.Index, e.Err.Error())
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+// pageHead packs page type into the low 4 bits and the codec of the value
+// into the high 4 bits of the first byte of a page
+func pageHead(t Type, c Codec) byte {
+	return byte(t) | byte(c)<<4
+}
+
+func headerLen(t Type) int {
+	if t.linked() {
+		return 7
+	}
+	return 3
+}
+
+func (t Type) linked() bool {
+	return t == TypeChained || t == TypeEmpty
+}
+
 func (v version) String() string {
 	max := binary.BigEndian.Uint16(v[0:2])
 	mid := binary.BigEndian.Uint16(v[2:4])
@@ -65,6 +107,12 @@ func init() {
 	copy(V010000[:], v)
 }
 
+// Rewriter is implemented by stores which can rewrite used pages in place,
+// Put only writes pages taken by Acquire
+type Rewriter interface {
+	Rewrite([]Block) error
+}
+
 type RWSC interface {
 	io.ReadWriteSeeker
 	io.Closer
@@ -82,14 +130,19 @@ type blockStore struct {
 	total    int
 	pagePool sync.Pool
 	prepared bool
+	readonly bool
+	lock     sync.RWMutex
+	crypt    *crypt
+	counters counters
 }
 
 var _ BlockStore = &blockStore{}
 
 type Block struct {
-	Type Type
-	Next int
-	Data []byte
+	Type  Type
+	Codec Codec
+	Next  int
+	Data  []byte
 
 	size     uint16
 	idx      int
@@ -102,6 +155,34 @@ func FileRWSC(pathfile string) func() (RWSC, error) {
 	}
 }
 
+// FileROSC opens pathfile with O_RDONLY, it never creates the file and
+// works on read-only files and filesystems. It's the opener OpenReadOnly
+// expects
+func FileROSC(pathfile string) func() (RWSC, error) {
+	return func() (RWSC, error) {
+		f, err := os.Open(pathfile)
+		if err != nil {
+			return nil, err
+		}
+		return roFile{f}, nil
+	}
+}
+
+// ReadOnlyRWSC is a RWSC opened for reading only, OpenReadOnly rejects
+// any other RWSC
+type ReadOnlyRWSC interface {
+	RWSC
+	ReadOnly() bool
+}
+
+type roFile struct {
+	*os.File
+}
+
+func (roFile) ReadOnly() bool {
+	return true
+}
+
 func (p Block) Size() uint16 {
 	return p.size
 }
@@ -123,7 +204,12 @@ func (s *blockStore) Create(v version, blockSize uint16) (err error) {
 	if em, e := s.emptyRWSC(); e != nil {
 		return e
 	} else if !em {
-		return fmt.Errorf("rwsc exists")
+		return ErrExists
+	}
+	if s.crypt != nil {
+		if err = s.crypt.init(); err != nil {
+			return
+		}
 	}
 	if _, err = s.rws.Write(magicNumber[:]); err != nil {
 		return
@@ -135,7 +221,7 @@ func (s *blockStore) Create(v version, blockSize uint16) (err error) {
 		return make([]byte, s.blockSize)
 	}
 	// write super block, this block can not free
-	if err := s.putPage(0, TypeSuper, 0, []byte{}); err != nil {
+	if err := s.putPage(0, TypeSuper, CodecNone, 0, []byte{}); err != nil {
 		return err
 	}
 	s.prepared = true
@@ -154,29 +240,44 @@ func (s *blockStore) Open() (err error) {
 		err = fmt.Errorf("can't open file: %w", err)
 		return
 	}
+	if ro, ok := s.rws.(ReadOnlyRWSC); s.readonly && (!ok || !ro.ReadOnly()) {
+		s.rws.Close()
+		return ErrNotReadOnly
+	}
 	if em, e := s.emptyRWSC(); e != nil {
 		return e
 	} else if em {
 		return ErrRWSCNotExists
 	}
 	bs := headerPool.Get().([]byte)
-	if _, err = s.rws.Read(bs); err != nil {
-		err = fmt.Errorf("file occurred when read metadata: %w", err)
+	if _, err = io.ReadFull(s.rws, bs); err != nil {
+		err = fmt.Errorf("%w: read metadata: %s", ErrCorrupt, err.Error())
 		return
 	}
 	if !bytes.Equal(bs[:magicSize], magicNumber[:]) {
-		err = fmt.Errorf("malformed format: %w", err)
+		err = fmt.Errorf("%w: malformed format", ErrCorrupt)
 		return
 	}
 	start := magicSize
 	if !bytes.Equal(bs[start+14:start+20], V010000[:]) {
-		err = fmt.Errorf("unsupported version")
+		var v version
+		copy(v[:], bs[start+14:start+20])
+		err = fmt.Errorf("%w: %s", ErrUnsupportedVersion, v)
 		return
 	}
+	copy(s.v[:], bs[start+14:start+20])
 	s.blockSize = binary.BigEndian.Uint16(bs[start : start+2])
 	s.freeHead = int(binary.BigEndian.Uint32(bs[start+2 : start+6]))
 	s.freeTail = int(binary.BigEndian.Uint32(bs[start+6 : start+10]))
 	s.total = int(binary.BigEndian.Uint32(bs[start+10 : start+14]))
+	if s.crypt != nil {
+		if err = s.crypt.load(bs[start+keyCheckAt:]); err != nil {
+			return
+		}
+	} else if bs[start+keyCheckAt]&flagEncrypted != 0 {
+		err = fmt.Errorf("%w: store is encrypted", ErrBadKey)
+		return
+	}
 
 	s.pagePool.New = func() interface{} {
 		return make([]byte, s.blockSize)
@@ -185,6 +286,17 @@ func (s *blockStore) Open() (err error) {
 	return nil
 }
 
+// OpenReadOnly opens the store for reading only, the opener must return a
+// ReadOnlyRWSC (e.g. FileROSC) so nothing is created or opened for writing
+func (s *blockStore) OpenReadOnly() error {
+	s.readonly = true
+	return s.Open()
+}
+
+func (s *blockStore) ReadOnly() bool {
+	return s.readonly
+}
+
 func (s *blockStore) Acquire(lenInBytes int) (blocks []Block, err error) {
 	count := int(math.Ceil(float64(lenInBytes) / float64(s.DataSize())))
 	blocks = make([]Block, count)
@@ -192,7 +304,7 @@ func (s *blockStore) Acquire(lenInBytes int) (blocks []Block, err error) {
 	if count > 0 {
 		ty = TypeChained
 	}
-	err = s.ensure(func() error {
+	err = s.ensureWritable(func() error {
 		i := 0
 		freeIdx := s.freeHead
 		for i < count {
@@ -219,7 +331,7 @@ func (s *blockStore) Acquire(lenInBytes int) (blocks []Block, err error) {
 			if i == count-1 {
 				next = 0
 			}
-			blocks[i] = Block{Type: ty, idx: idx, size: s.blockSize - 7, allocate: true, Next: next}
+			blocks[i] = Block{Type: ty, idx: idx, size: s.DataSize(), allocate: true, Next: next}
 		}
 		return nil
 	})
@@ -227,15 +339,18 @@ func (s *blockStore) Acquire(lenInBytes int) (blocks []Block, err error) {
 }
 
 func (s *blockStore) Erase(idx int) error {
-	return s.ensure(func() error {
+	return s.ensureWritable(func() error {
 		if idx == 0 {
-			return errors.New("super block can not be erased")
+			return &PageError{Index: idx, Err: ErrInvalidBlock}
 		}
-		if err := s.putPage(idx, TypeEmpty, 0, []byte{}); err != nil {
+		if err := s.ensureUsed(idx); err != nil {
+			return err
+		}
+		if err := s.putPage(idx, TypeEmpty, CodecNone, 0, []byte{}); err != nil {
 			return err
 		}
 		if s.freeTail != 0 {
-			if err := s.putPage(s.freeTail, TypeEmpty, idx, []byte{}); err != nil {
+			if err := s.putPage(s.freeTail, TypeEmpty, CodecNone, idx, []byte{}); err != nil {
 				return err
 			}
 		}
@@ -243,23 +358,32 @@ func (s *blockStore) Erase(idx int) error {
 		if s.freeHead == 0 {
 			s.freeHead = idx
 		}
+		s.counters.erase(idx)
 		return s.syncMetaData()
 	})
 }
 
-func (s *blockStore) putPage(idx int, t Type, next int, data []byte) error {
+func (s *blockStore) putPage(idx int, t Type, c Codec, next int, data []byte) error {
 	bs := s.pagePool.Get().([]byte)
 	defer s.pagePool.Put(bs)
-	bs[0] = byte(t)
-	binary.BigEndian.PutUint16(bs[1:3], uint16(len(data)))
-	var hl = 3
-	if t == TypeChained {
-		binary.BigEndian.PutUint32(bs[3:7], uint32(next))
-		hl = 7
-	}
+	hl := headerLen(t)
 	max := int(s.blockSize) - hl
+	if s.crypt != nil && t != TypeEmpty {
+		max -= cryptOverhead
+	}
 	if len(data) > int(max) {
-		return fmt.Errorf("max user data length is %d", max)
+		return &PageError{Index: idx, Err: fmt.Errorf("%w: max user data length is %d", ErrValueTooLarge, max)}
+	}
+	if s.crypt != nil && t != TypeEmpty {
+		var err error
+		if data, err = s.crypt.seal(idx, pageHead(t, c), next, data); err != nil {
+			return err
+		}
+	}
+	bs[0] = pageHead(t, c)
+	binary.BigEndian.PutUint16(bs[1:3], uint16(len(data)))
+	if t.linked() {
+		binary.BigEndian.PutUint32(bs[3:7], uint32(next))
 	}
 	copy(bs[hl:hl+len(data)], data)
 	pos := s.blockAt(idx)
@@ -267,74 +391,113 @@ func (s *blockStore) putPage(idx int, t Type, next int, data []byte) error {
 		return err
 	}
 	writable := bs[:hl+len(data)]
-	if _, err := s.rws.Write(writable); err != nil {
-		return err
-	}
-	return nil
+	n, err := s.rws.Write(writable)
+	s.counters.write(idx, n)
+	return err
 }
 
 func (s *blockStore) Put(pages []Block) error {
-	return s.ensure(func() error {
+	return s.ensureWritable(func() error {
 		freeHead := s.freeHead
 		var err error
 		for i := range pages {
 			if pages[i].Type == TypeEmpty {
-				return fmt.Errorf("can't put free block")
+				return &PageError{Index: pages[i].idx, Err: ErrInvalidBlock}
 			}
 			if pages[i].Type == TypeSuper && pages[i].idx != 0 {
-				return fmt.Errorf("super block must at position 0")
+				return &PageError{Index: pages[i].idx, Err: ErrInvalidBlock}
 			}
-			if !pages[i].allocate && pages[i].idx != freeHead {
-				return fmt.Errorf("put must begin with free head")
+			if !pages[i].allocate {
+				// put must begin with free head
+				if freeHead == 0 || pages[i].idx != freeHead {
+					return &PageError{Index: pages[i].idx, Err: ErrInvalidBlock}
+				}
+				// take the next free page before the head is overwritten
+				if freeHead, err = s.nextFreeBlock(freeHead); err != nil {
+					return err
+				}
 			}
-			if err := s.putPage(pages[i].idx, pages[i].Type, pages[i].Next, pages[i].Data); err != nil {
+			if err := s.putPage(pages[i].idx, pages[i].Type, pages[i].Codec, pages[i].Next, pages[i].Data); err != nil {
 				return err
 			}
 			if pages[i].allocate {
 				s.total += 1
 			}
-			if i == len(pages)-1 {
-				continue
-			}
-			if pages[i+1].allocate {
-				freeHead = 0
-				s.freeTail = 0
-			} else if freeHead, err = s.nextFreeBlock(freeHead); err != nil {
-				return err
-			}
 		}
 		s.freeHead = freeHead
 		if s.freeHead == 0 {
 			s.freeTail = 0
 		}
-		s.syncMetaData()
+		return s.syncMetaData()
+	})
+}
+
+// Rewrite writes pages which are in use in place, the pages must be taken
+// from the store by Acquire and put before
+func (s *blockStore) Rewrite(pages []Block) error {
+	return s.ensureWritable(func() error {
+		for i := range pages {
+			if pages[i].Type == TypeEmpty || pages[i].Type == TypeSuper {
+				return &PageError{Index: pages[i].idx, Err: ErrInvalidBlock}
+			}
+			if err := s.ensureUsed(pages[i].idx); err != nil {
+				return err
+			}
+		}
+		for i := range pages {
+			if err := s.putPage(pages[i].idx, pages[i].Type, pages[i].Codec, pages[i].Next, pages[i].Data); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
 
 func (s *blockStore) Get(idx int, page *Block) error {
-	return s.ensure(func() error {
+	return s.ensureRead(func() error {
 		bs := s.pagePool.Get().([]byte)
 		defer s.pagePool.Put(bs)
-		pos := s.blockAt(idx)
-		if _, err := s.rws.Seek(pos, io.SeekStart); err != nil {
-			return err
-		}
-		if _, err := s.rws.Read(bs); err != nil {
+		return s.readPage(bs, idx, page)
+	})
+}
+
+// readPage reads the raw page idx into bs and decodes it into page
+func (s *blockStore) readPage(bs []byte, idx int, page *Block) error {
+	n, err := s.readAt(bs, idx)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	// the last page of the file may be shorter than a block, but never
+	// shorter than its header and data
+	if n < 3 {
+		return &PageError{Index: idx, Err: ErrInvalidBlock}
+	}
+	page.Type = Type(bs[0] & 0x0f)
+	page.Codec = Codec(bs[0] >> 4)
+	page.size = binary.BigEndian.Uint16(bs[1:3])
+	hl := headerLen(page.Type)
+	if page.Type.linked() {
+		page.Next = int(binary.BigEndian.Uint32(bs[3:7]))
+	}
+	if hl+int(page.size) > len(bs) {
+		return &PageError{Index: idx, Err: ErrCorrupt}
+	}
+	if hl+int(page.size) > n {
+		return &PageError{Index: idx, Err: ErrInvalidBlock}
+	}
+	page.idx = idx
+	if s.crypt != nil && page.Type != TypeEmpty {
+		data, err := s.crypt.open(idx, bs[0], page.Next, bs[hl:hl+int(page.size)])
+		if err != nil {
 			return err
 		}
-		page.Type = Type(bs[0])
-		page.size = binary.BigEndian.Uint16(bs[1:3])
-		hl := 3
-		if page.Type == TypeChained {
-			page.Next = int(binary.BigEndian.Uint32(bs[3:7]))
-			hl = 7
-		}
-		page.Data = make([]byte, page.size)
-		page.idx = idx
-		copy(page.Data, bs[hl:hl+int(page.size)])
+		page.Data = data
+		page.size = uint16(len(data))
 		return nil
-	})
+	}
+	page.Data = make([]byte, page.size)
+	copy(page.Data, bs[hl:hl+int(page.size)])
+	return nil
 }
 
 func (s *blockStore) From(idx int) (blocks []Block, err error) {
@@ -362,6 +525,17 @@ func (s *blockStore) WriteTo(w io.Writer, idx int) (blocks []Block, err error) {
 	if blocks, err = s.From(idx); err != nil {
 		return
 	}
+	// every page of a value records the codec of the value
+	for i := range blocks {
+		if blocks[i].Codec != blocks[0].Codec {
+			err = &PageError{Index: blocks[i].idx, Err: fmt.Errorf("%w: codec %s in a %s value", ErrCorrupt, blocks[i].Codec, blocks[0].Codec)}
+			return
+		}
+	}
+	if len(blocks) > 0 && blocks[0].Codec != CodecNone {
+		err = blocks[0].Codec.decompress(w, blocks)
+		return
+	}
 	for _, block := range blocks {
 		if _, err = w.Write(block.Data); err != nil {
 			return
@@ -377,20 +551,36 @@ func (s *blockStore) blockAt(idx int) int64 {
 func (s *blockStore) nextFreeBlock(freeIdx int) (nextIdx int, err error) {
 	bs := s.pagePool.Get().([]byte)
 	defer s.pagePool.Put(bs)
-	pos := s.blockAt(freeIdx)
-	if _, err = s.rws.Seek(pos, io.SeekStart); err != nil {
+	var n int
+	if n, err = s.readAt(bs[:7], freeIdx); n < 7 {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		err = &PageError{Index: freeIdx, Err: fmt.Errorf("%w: %s", ErrCorrupt, err.Error())}
 		return
 	}
-	if _, err = s.rws.Read(bs[:7]); err != nil {
+	err = nil
+	if Type(bs[0]&0x0f) != TypeEmpty {
+		err = &PageError{Index: freeIdx, Err: ErrBadFreeList}
 		return
 	}
-	if Type(bs[0]) != TypeEmpty {
-		panic("not a free page")
-	}
 	nextIdx = int(binary.BigEndian.Uint32(bs[3:7]))
 	return
 }
 
+// ensureUsed checks idx is an allocated page which isn't in the free list
+func (s *blockStore) ensureUsed(idx int) error {
+	if idx <= 0 || idx > s.total {
+		return &PageError{Index: idx, Err: ErrInvalidBlock}
+	}
+	if _, err := s.nextFreeBlock(idx); err == nil {
+		return &PageError{Index: idx, Err: ErrBadFreeList}
+	} else if !errors.Is(err, ErrBadFreeList) {
+		return err
+	}
+	return nil
+}
+
 func (s *blockStore) syncMetaData() error {
 	bs := metaPool.Get().([]byte)
 	start := 0
@@ -399,20 +589,62 @@ func (s *blockStore) syncMetaData() error {
 	binary.BigEndian.PutUint32(bs[start+6:start+10], uint32(s.freeTail))
 	binary.BigEndian.PutUint32(bs[start+10:start+14], uint32(s.total))
 	copy(bs[start+14:start+20], s.v[:])
+	if s.crypt != nil {
+		if err := s.crypt.sync(bs[start+keyCheckAt:]); err != nil {
+			return err
+		}
+	}
 	if _, err := s.rws.Seek(magicSize, io.SeekStart); err != nil {
 		return err
 	}
-	_, err := s.rws.Write(bs)
+	n, err := s.rws.Write(bs)
+	s.counters.sync(n)
 	return err
 }
 
 func (s *blockStore) ensure(handle func() error) error {
 	if !s.prepared {
-		return errors.New("you should call Open or Create before any operation")
+		return ErrNotPrepared
 	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	return handle()
 }
 
+// ensureRead shares the lock between readers when pages are read with
+// io.ReaderAt, which doesn't move the offset of the store
+func (s *blockStore) ensureRead(handle func() error) error {
+	if _, ok := s.rws.(io.ReaderAt); !ok {
+		return s.ensure(handle)
+	}
+	if !s.prepared {
+		return ErrNotPrepared
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return handle()
+}
+
+func (s *blockStore) ensureWritable(handle func() error) error {
+	if s.readonly {
+		return ErrReadOnly
+	}
+	return s.ensure(handle)
+}
+
+// readAt reads page idx into bs, it prefers io.ReaderAt so that read only
+// stores backed by files don't move the shared offset
+func (s *blockStore) readAt(bs []byte, idx int) (n int, err error) {
+	pos := s.blockAt(idx)
+	if ra, ok := s.rws.(io.ReaderAt); ok {
+		n, err = ra.ReadAt(bs, pos)
+	} else if _, err = s.rws.Seek(pos, io.SeekStart); err == nil {
+		n, err = s.rws.Read(bs)
+	}
+	s.counters.read(idx, n)
+	return
+}
+
 func (s *blockStore) emptyRWSC() (em bool, err error) {
 	var total int64
 	if total, err = s.rws.Seek(0, os.SEEK_END); err != nil {
@@ -424,5 +656,8 @@ func (s *blockStore) emptyRWSC() (em bool, err error) {
 }
 
 func (s *blockStore) DataSize() uint16 {
+	if s.crypt != nil {
+		return s.blockSize - 7 - cryptOverhead
+	}
 	return s.blockSize - 7
 }
@@ -2,6 +2,7 @@ package inf
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"testing"
 )
@@ -87,6 +88,14 @@ func Test_blockStore_Get(t *testing.T) {
 			if block.Type != TypeSuper {
 				t.Fatalf("super block's type error")
 			}
+			// stale bytes of an earlier read must not be decoded
+			blocks, _ := s.Acquire(10)
+			blocks[0].Data = []byte("hello")
+			s.Put(blocks)
+			s.Get(blocks[0].Index(), &block)
+			if err := s.Get(blocks[0].Index()+1, &block); !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("get a block past the end should be ErrInvalidBlock, got %v", err)
+			}
 		})
 	})
 }
@@ -126,3 +135,135 @@ func Test_blockStore(t *testing.T) {
 		})
 	})
 }
+
+func Test_blockStore_OpenReadOnly(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			blocks, _ := s.Acquire(11)
+			blocks[0].Data = []byte("hello world")
+			if err := s.Put(blocks); err != nil {
+				t.Fatalf("put data error: %s", err.Error())
+			}
+		})
+		if err := os.Chmod("./block.fsf", 0444); err != nil {
+			t.Fatalf("chmod error: %s", err.Error())
+		}
+		readers := []*blockStore{New(FileROSC("./block.fsf")), New(FileROSC("./block.fsf"))}
+		for _, r := range readers {
+			if err := r.OpenReadOnly(); err != nil {
+				t.Fatalf("open read only error: %s", err.Error())
+			}
+			defer r.Close()
+			var buf bytes.Buffer
+			if _, err := r.WriteTo(&buf, 1); err != nil {
+				t.Fatalf("write to error: %s", err.Error())
+			}
+			if buf.String() != "hello world" {
+				t.Fatalf("read error")
+			}
+			if _, err := r.Acquire(10); err != ErrReadOnly {
+				t.Fatalf("acquire should be rejected")
+			}
+			if err := r.Put([]Block{{Type: TypeSingle, idx: 1}}); err != ErrReadOnly {
+				t.Fatalf("put should be rejected")
+			}
+			if err := r.Erase(1); err != ErrReadOnly {
+				t.Fatalf("erase should be rejected")
+			}
+		}
+		if err := New(FileRWSC("./block.fsf")).OpenReadOnly(); err != ErrNotReadOnly {
+			t.Fatalf("writable opener should be rejected: %v", err)
+		}
+		if err := New(FileROSC("./missing.fsf")).OpenReadOnly(); err == nil {
+			t.Fatalf("missing file should not be opened")
+		}
+		if _, err := os.Stat("./missing.fsf"); !os.IsNotExist(err) {
+			t.Fatalf("missing file should not be created")
+		}
+	})
+}
+
+func Test_blockStore_reuse(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			blocks, _ := s.Acquire(2000)
+			for i := range blocks {
+				blocks[i].Data = []byte("x")
+			}
+			if err := s.Put(blocks); err != nil {
+				t.Fatalf("put data error: %s", err.Error())
+			}
+			for _, idx := range []int{1, 2, 3} {
+				if err := s.Erase(idx); err != nil {
+					t.Fatalf("erase %d error: %s", idx, err.Error())
+				}
+			}
+			blocks, _ = s.Acquire(1000)
+			if len(blocks) != 2 || blocks[0].Index() != 1 || blocks[1].Index() != 2 {
+				t.Fatalf("acquire should reuse free blocks")
+			}
+			for i := range blocks {
+				blocks[i].Data = []byte("y")
+			}
+			if err := s.Put(blocks); err != nil {
+				t.Fatalf("put data error: %s", err.Error())
+			}
+			if s.freeHead != 3 || s.freeTail != 3 || s.total != 4 {
+				t.Fatalf("free list error after reuse")
+			}
+		})
+	})
+}
+
+func Test_blockStore_errors(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			blocks, _ := s.Acquire(10)
+			blocks[0].Data = []byte("hello")
+			if err := s.Put(blocks); err != nil {
+				t.Fatalf("put data error: %s", err.Error())
+			}
+			if err := s.Erase(1); err != nil {
+				t.Fatalf("erase error: %s", err.Error())
+			}
+			err := s.Erase(1)
+			if !errors.Is(err, ErrBadFreeList) {
+				t.Fatalf("erase a free block should be ErrBadFreeList")
+			}
+			var pe *PageError
+			if !errors.As(err, &pe) || pe.Index != 1 {
+				t.Fatalf("erase error should be a PageError of block 1")
+			}
+			blocks, _ = s.Acquire(10)
+			blocks[0].Data = make([]byte, 1024)
+			if err := s.Put(blocks); !errors.Is(err, ErrValueTooLarge) {
+				t.Fatalf("put too large data should be ErrValueTooLarge")
+			}
+			if err := s.Erase(0); !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("erase super block should be ErrInvalidBlock")
+			}
+			used, _ := s.Acquire(10)
+			used[0].Data = []byte("used")
+			s.Put(used)
+			used[0].Data = []byte("again")
+			if err := s.Put(used); !errors.Is(err, ErrInvalidBlock) {
+				t.Fatalf("put a used block should be ErrInvalidBlock, got %v", err)
+			}
+			if err := s.Rewrite(used); err != nil {
+				t.Fatalf("rewrite error: %s", err.Error())
+			}
+			var block Block
+			if s.Get(used[0].Index(), &block); string(block.Data) != "again" {
+				t.Fatalf("rewrite should write the block in place")
+			}
+			s.Erase(used[0].Index())
+			if err := s.Rewrite(used); !errors.Is(err, ErrBadFreeList) {
+				t.Fatalf("rewrite a free block should be ErrBadFreeList, got %v", err)
+			}
+		})
+		s := New(FileRWSC("./block.fsf"))
+		if err := s.Create(V010000, 512); !errors.Is(err, ErrExists) {
+			t.Fatalf("create on existing rwsc should be ErrExists")
+		}
+	})
+}
@@ -0,0 +1,561 @@
+package inf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// bplusTree keeps all elements in leaves which are linked to their
+// neighbours, interior nodes only keep separators, which are the keys of
+// pairs, so interior nodes fan out more and scans walk the leaf links
+type bplusTree struct {
+	root   *bplusNode
+	total  uint16 // total bytes of a node
+	cmp    Comparator
+	lock   sync.RWMutex
+	count  int
+	mod    uint64 // changed on every write, so scans know to seek again
+	store  BlockStore
+	meta   int   // meta block of the tree in store
+	blocks []int // node blocks written by the last Sync
+}
+
+var bplusMagic = [4]byte{'b', 'p', 'l', 's'}
+
+// bplusNode is a leaf if children is nil. The ith child of an interior node
+// holds the elements in [items[i-1], items[i])
+type bplusNode struct {
+	items      []Storeable
+	children   []*bplusNode
+	prev, next *bplusNode
+	p          *bplusNode
+}
+
+func NewBPlusTree(total uint16) *bplusTree {
+	return &bplusTree{total: total}
+}
+
+// NewStoreBPlusTree creates a tree whose nodes are written into pages of
+// store by Sync, a node page holds total bytes at most
+func NewStoreBPlusTree(total uint16, store BlockStore) *bplusTree {
+	return &bplusTree{total: total, store: store}
+}
+
+func (tree *bplusTree) SetComparator(c Comparator) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root != nil {
+		return fmt.Errorf("%w: tree is not empty", ErrComparator)
+	}
+	tree.cmp = c
+	return nil
+}
+
+func (tree *bplusTree) compare(a, b Storeable) int {
+	if tree.cmp.Compare != nil {
+		pa, aok := a.(pair)
+		pb, bok := b.(pair)
+		if aok && bok {
+			return tree.cmp.Compare(pa.Key, pb.Key)
+		}
+	}
+	return a.Compare(b)
+}
+
+func (tree *bplusTree) elemMax() int {
+	return (int(tree.total) - tree.nodeHead()) / 2
+}
+
+// nodeHead and elemHead are the bytes a node and an element take besides the
+// elements, the page headers with a store
+func (tree *bplusTree) nodeHead() int {
+	if tree.store != nil {
+		return nodeHeadSize
+	}
+	return 6
+}
+
+func (tree *bplusTree) elemHead() int {
+	if tree.store != nil {
+		return elemHeadSize + 4
+	}
+	return 0
+}
+
+func (tree *bplusTree) Comparator() Comparator {
+	if tree.cmp.Compare == nil {
+		return BytesComparator
+	}
+	return tree.cmp
+}
+
+// separator returns what an interior node keeps between last and first,
+// which are the last element of a leaf and the first of the next. It's the
+// shortest prefix of the key of first which is after the key of last if keys
+// are compared as bytes, or the key of first
+func (tree *bplusTree) separator(last, first Storeable) Storeable {
+	pf, ok := first.(pair)
+	if !ok {
+		return first
+	}
+	if pl, ok := last.(pair); ok && (tree.cmp.Compare == nil || tree.cmp.Name == BytesComparator.Name) {
+		if l := sharedPrefix(pl.Key, pf.Key) + 1; l < len(pf.Key) {
+			return pair{Key: pf.Key[:l:l]}
+		}
+	}
+	return pair{Key: pf.Key}
+}
+
+// search returns the position of data in items and whether it's there
+func (tree *bplusTree) search(items []Storeable, data Storeable) (int, bool) {
+	left, right := 0, len(items)-1
+	for left <= right {
+		mid := left + (right-left)/2
+		r := tree.compare(items[mid], data)
+		if r == 0 {
+			return mid, true
+		} else if r > 0 {
+			right = mid - 1
+		} else {
+			left = mid + 1
+		}
+	}
+	return left, false
+}
+
+// leaf returns the leaf data belongs to
+func (tree *bplusTree) leaf(data Storeable) *bplusNode {
+	n := tree.root
+	for n != nil && n.children != nil {
+		pos, exactly := tree.search(n.items, data)
+		if exactly {
+			pos++
+		}
+		n = n.children[pos]
+	}
+	return n
+}
+
+func (tree *bplusTree) Len() int {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.count
+}
+
+func (tree *bplusTree) Get(data Storeable) (Storeable, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	n := tree.leaf(data)
+	if n == nil {
+		return nil, ErrNotFound
+	}
+	if pos, exactly := tree.search(n.items, data); exactly {
+		return n.items[pos], nil
+	}
+	return nil, ErrNotFound
+}
+
+func (tree *bplusTree) Put(data Storeable) error {
+	if tree.elemHead()+data.Size(tree.elemMax()) > tree.elemMax() {
+		return fmt.Errorf("%w: element takes more than %d bytes", ErrValueTooLarge, tree.elemMax())
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.mod++
+	if tree.root == nil {
+		tree.root = &bplusNode{items: []Storeable{data}}
+		tree.count = 1
+		return nil
+	}
+	n := tree.leaf(data)
+	pos, exactly := tree.search(n.items, data)
+	if exactly {
+		n.items[pos] = data
+	} else {
+		n.items = insertItem(n.items, pos, data)
+		tree.count++
+	}
+	tree.split(n)
+	return nil
+}
+
+func (tree *bplusTree) Del(data Storeable) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	n := tree.leaf(data)
+	if n == nil {
+		return ErrNotFound
+	}
+	pos, exactly := tree.search(n.items, data)
+	if !exactly {
+		return ErrNotFound
+	}
+	tree.mod++
+	tree.count--
+	n.items = append(n.items[:pos], n.items[pos+1:]...)
+	tree.rebalance(n)
+	return nil
+}
+
+func insertItem(items []Storeable, pos int, data Storeable) []Storeable {
+	return append(items[:pos], append([]Storeable{data}, items[pos:]...)...)
+}
+
+func (tree *bplusTree) used(n *bplusNode) int {
+	used := tree.nodeHead()
+	for _, item := range n.items {
+		used += tree.elemHead() + item.Size(tree.elemMax())
+	}
+	return used
+}
+
+// middle returns where to split n, the items before and after it use about
+// the same bytes
+func (tree *bplusTree) middle(n *bplusNode, lo, hi int) int {
+	half := (tree.used(n) - tree.nodeHead()) / 2
+	used, pos := 0, 0
+	for ; pos < len(n.items); pos++ {
+		if used += tree.elemHead() + n.items[pos].Size(tree.elemMax()); used > half {
+			break
+		}
+	}
+	if pos < lo {
+		return lo
+	}
+	if pos > hi {
+		return hi
+	}
+	return pos
+}
+
+// split splits n and its ancestors while they overflow
+func (tree *bplusTree) split(n *bplusNode) {
+	for tree.used(n) > int(tree.total) {
+		var nn *bplusNode
+		var sep Storeable
+		if n.children == nil {
+			if len(n.items) < 2 {
+				return
+			}
+			m := tree.middle(n, 1, len(n.items)-1)
+			nn = &bplusNode{items: append([]Storeable{}, n.items[m:]...), prev: n, next: n.next}
+			n.items = n.items[:m:m]
+			if n.next != nil {
+				n.next.prev = nn
+			}
+			n.next = nn
+			sep = tree.separator(n.items[m-1], nn.items[0])
+		} else {
+			if len(n.items) < 3 {
+				return
+			}
+			m := tree.middle(n, 1, len(n.items)-2)
+			sep = n.items[m]
+			nn = &bplusNode{
+				items:    append([]Storeable{}, n.items[m+1:]...),
+				children: append([]*bplusNode{}, n.children[m+1:]...),
+			}
+			for _, c := range nn.children {
+				c.p = nn
+			}
+			n.items = n.items[:m:m]
+			n.children = n.children[: m+1 : m+1]
+		}
+		if n.p == nil {
+			tree.root = &bplusNode{items: []Storeable{sep}, children: []*bplusNode{n, nn}}
+			n.p, nn.p = tree.root, tree.root
+			return
+		}
+		p := n.p
+		nn.p = p
+		ci := p.index(n)
+		p.items = insertItem(p.items, ci, sep)
+		p.children = append(p.children[:ci+1], append([]*bplusNode{nn}, p.children[ci+1:]...)...)
+		n = p
+	}
+}
+
+func (n *bplusNode) index(c *bplusNode) int {
+	for i, child := range n.children {
+		if child == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// rebalance fixes the leaf n which uses less than half of a node after a
+// delete like the btree does. It merges n with a sibling if they fit in one
+// node, otherwise it borrows an element from a sibling with more than one
+// element and updates the separator between them
+func (tree *bplusTree) rebalance(n *bplusNode) {
+	if n.p == nil {
+		if len(n.items) == 0 {
+			tree.root = nil
+		}
+		return
+	}
+	if tree.used(n) >= int(tree.total)/2 {
+		return
+	}
+	p := n.p
+	ci := p.index(n)
+	var left, right *bplusNode
+	if ci > 0 {
+		left = p.children[ci-1]
+	}
+	if ci < len(p.children)-1 {
+		right = p.children[ci+1]
+	}
+	switch {
+	case left != nil && tree.fitsWith(left, n):
+		left.items = append(left.items, n.items...)
+		tree.unlink(n)
+		tree.removeChild(p, ci)
+	case right != nil && tree.fitsWith(n, right):
+		n.items = append(n.items, right.items...)
+		tree.unlink(right)
+		tree.removeChild(p, ci+1)
+	case left != nil && len(left.items) > 1:
+		last := len(left.items) - 1
+		n.items = insertItem(n.items, 0, left.items[last])
+		left.items = left.items[:last:last]
+		p.items[ci-1] = tree.separator(left.items[last-1], n.items[0])
+		tree.split(p)
+	case right != nil && len(right.items) > 1:
+		n.items = append(n.items, right.items[0])
+		right.items = right.items[1:]
+		p.items[ci] = tree.separator(n.items[len(n.items)-1], right.items[0])
+		tree.split(p)
+	}
+}
+
+// fitsWith tells whether the elements of the leaves a and b fit in one node
+func (tree *bplusTree) fitsWith(a, b *bplusNode) bool {
+	return tree.used(a)+tree.used(b)-tree.nodeHead() <= int(tree.total)
+}
+
+// unlink removes the leaf n from the leaf links
+func (tree *bplusTree) unlink(n *bplusNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	}
+}
+
+// removeChild removes the ith child of n with the separator next to it, an
+// interior node left with one child borrows from or merges into a sibling
+func (tree *bplusTree) removeChild(n *bplusNode, ci int) {
+	if ci > 0 {
+		n.items = append(n.items[:ci-1], n.items[ci:]...)
+	} else {
+		n.items = n.items[1:]
+	}
+	n.children = append(n.children[:ci], n.children[ci+1:]...)
+	if len(n.items) > 0 {
+		return
+	}
+	if n.p == nil {
+		tree.root = n.children[0]
+		tree.root.p = nil
+		return
+	}
+	p := n.p
+	ci = p.index(n)
+	var left, right *bplusNode
+	if ci > 0 {
+		left = p.children[ci-1]
+	}
+	if ci < len(p.children)-1 {
+		right = p.children[ci+1]
+	}
+	switch {
+	case left != nil && len(left.items) > 1:
+		last := len(left.items) - 1
+		n.items = []Storeable{p.items[ci-1]}
+		n.children = append([]*bplusNode{left.children[last+1]}, n.children...)
+		n.children[0].p = n
+		p.items[ci-1] = left.items[last]
+		left.items = left.items[:last]
+		left.children = left.children[:last+1]
+		tree.split(p)
+	case right != nil && len(right.items) > 1:
+		n.items = []Storeable{p.items[ci]}
+		n.children = append(n.children, right.children[0])
+		n.children[1].p = n
+		p.items[ci] = right.items[0]
+		right.items = right.items[1:]
+		right.children = right.children[1:]
+		tree.split(p)
+	case left != nil:
+		left.items = append(left.items, p.items[ci-1])
+		left.children = append(left.children, n.children[0])
+		n.children[0].p = left
+		tree.removeChild(p, ci)
+		tree.split(left)
+	default:
+		right.items = append([]Storeable{p.items[ci]}, right.items...)
+		right.children = append([]*bplusNode{n.children[0]}, right.children...)
+		n.children[0].p = right
+		tree.removeChild(p, ci)
+		tree.split(right)
+	}
+}
+
+// Scan iterates over the elements in [start, end) in order by the leaf
+// links, nil start or end means unbounded. Every Next locks the tree for
+// reading, if the tree is changed between, the scan seeks from the last
+// element again
+func (tree *bplusTree) Scan(start, end Storeable) Iterator {
+	return &bplusIterator{tree: tree, start: start, end: end}
+}
+
+type bplusIterator struct {
+	tree       *bplusTree
+	start, end Storeable
+	n          *bplusNode
+	pos        int
+	mod        uint64
+	data       Storeable
+	done       bool
+}
+
+func (it *bplusIterator) Next() bool {
+	tree := it.tree
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if it.done {
+		return false
+	}
+	if it.data == nil || it.mod != tree.mod {
+		it.seek()
+	} else {
+		it.pos++
+	}
+	for it.n != nil && it.pos >= len(it.n.items) {
+		it.n, it.pos = it.n.next, 0
+	}
+	if it.n == nil || (it.end != nil && tree.compare(it.n.items[it.pos], it.end) >= 0) {
+		it.done = true
+		return false
+	}
+	it.data = it.n.items[it.pos]
+	return true
+}
+
+// seek positions it at the first element after the last one returned, or at
+// start
+func (it *bplusIterator) seek() {
+	tree := it.tree
+	it.mod = tree.mod
+	from := it.start
+	if it.data != nil {
+		from = it.data
+	}
+	if from == nil {
+		it.n, it.pos = tree.root, 0
+		for it.n != nil && it.n.children != nil {
+			it.n = it.n.children[0]
+		}
+		return
+	}
+	it.n = tree.leaf(from)
+	if it.n == nil {
+		return
+	}
+	pos, exactly := tree.search(it.n.items, from)
+	if exactly && it.data != nil {
+		pos++
+	}
+	it.pos = pos
+}
+
+func (it *bplusIterator) Data() Storeable {
+	return it.data
+}
+
+func (it *bplusIterator) Err() error {
+	return nil
+}
+
+// Check verifies the elements and separators are ordered, parent pointers and
+// leaf links are right, all leaves are at the same depth and no node
+// overflows. The first violation is returned as ErrCorrupt
+func (tree *bplusTree) Check() error {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil {
+		if tree.count != 0 {
+			return fmt.Errorf("%w: empty tree counts %d", ErrCorrupt, tree.count)
+		}
+		return nil
+	}
+	var leaves []*bplusNode
+	depth := -1
+	var walk func(n *bplusNode, lo, hi Storeable, d int) error
+	walk = func(n *bplusNode, lo, hi Storeable, d int) error {
+		if len(n.items) == 0 {
+			return fmt.Errorf("%w: node at depth %d is empty", ErrCorrupt, d)
+		}
+		if tree.used(n) > int(tree.total) && len(n.items) > 1 {
+			return fmt.Errorf("%w: node at depth %d overflows", ErrCorrupt, d)
+		}
+		for i, item := range n.items {
+			if (i > 0 && tree.compare(n.items[i-1], item) >= 0) ||
+				(lo != nil && tree.compare(item, lo) < 0) || (hi != nil && tree.compare(item, hi) >= 0) {
+				return fmt.Errorf("%w: item %d at depth %d is out of order", ErrCorrupt, i, d)
+			}
+		}
+		if n.children == nil {
+			if depth == -1 {
+				depth = d
+			} else if depth != d {
+				return fmt.Errorf("%w: leaf at depth %d, others at %d", ErrCorrupt, d, depth)
+			}
+			leaves = append(leaves, n)
+			return nil
+		}
+		if len(n.children) != len(n.items)+1 {
+			return fmt.Errorf("%w: node at depth %d has %d children", ErrCorrupt, d, len(n.children))
+		}
+		for i, c := range n.children {
+			if c.p != n {
+				return fmt.Errorf("%w: parent of child %d at depth %d is wrong", ErrCorrupt, i, d)
+			}
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = n.items[i-1]
+			}
+			if i < len(n.items) {
+				chi = n.items[i]
+			}
+			if err := walk(c, clo, chi, d+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(tree.root, nil, nil, 0); err != nil {
+		return err
+	}
+	count := 0
+	for i, leaf := range leaves {
+		count += len(leaf.items)
+		var prev, next *bplusNode
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev || leaf.next != next {
+			return fmt.Errorf("%w: links of leaf %d are wrong", ErrCorrupt, i)
+		}
+	}
+	if count != tree.count {
+		return fmt.Errorf("%w: tree counts %d, has %d", ErrCorrupt, tree.count, count)
+	}
+	return nil
+}
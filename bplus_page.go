@@ -0,0 +1,142 @@
+package inf
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Sync writes all nodes of the tree into new node pages of its store, then
+// erases the pages written by the last Sync. A leaf is a leaf page, an
+// interior node is an interior page whose elements are the separators, the
+// leaf links are rebuilt when the tree is opened. It returns the index of the
+// meta block which OpenBPlusTree accepts
+func (tree *bplusTree) Sync() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.store == nil {
+		return 0, fmt.Errorf("%w: tree has no store", ErrNotPrepared)
+	}
+	old := tree.blocks
+	tree.blocks = nil
+	unsync := func(err error) error {
+		written := tree.blocks
+		tree.blocks = old
+		return erasePages(tree.store, written, err)
+	}
+	m := treeMeta{total: tree.total, cmp: tree.Comparator().Name}
+	if tree.root != nil {
+		var err error
+		if m.root, err = tree.write(tree.root); err != nil {
+			return 0, unsync(err)
+		}
+	}
+	data, err := encodeMeta(bplusMagic, m)
+	if err != nil {
+		return 0, unsync(err)
+	}
+	if tree.meta, err = syncMeta(tree.store, tree.meta, data); err != nil {
+		return 0, unsync(err)
+	}
+	for _, idx := range old {
+		if err := eraseChain(tree.store, idx); err != nil {
+			return 0, err
+		}
+	}
+	return tree.meta, nil
+}
+
+// write puts the pages of n and its children into store, it returns the
+// index of the page of n
+func (tree *bplusTree) write(n *bplusNode) (int, error) {
+	page := &nodePage{leaf: n.children == nil, elems: make([]pageElem, len(n.items))}
+	for i, item := range n.items {
+		data, ok := item.(pair)
+		if !ok {
+			return 0, fmt.Errorf("%w: only pair can be stored", ErrInvalidBlock)
+		}
+		page.elems[i].data = data
+	}
+	for i, c := range n.children {
+		idx, err := tree.write(c)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			page.first = idx
+		} else {
+			page.elems[i-1].after = idx
+		}
+	}
+	blocks, err := storeValue(tree.store, encodeNode(page), CodecNone)
+	if err != nil {
+		return 0, err
+	}
+	tree.blocks = append(tree.blocks, blocks[0].Index())
+	return blocks[0].Index(), nil
+}
+
+// OpenBPlusTree loads the tree whose meta block is meta from store, the
+// comparator is resolved by the name persisted in the meta block
+func OpenBPlusTree(store BlockStore, meta int) (*bplusTree, error) {
+	m, err := readMeta(store, meta, bplusMagic)
+	if err != nil {
+		return nil, err
+	}
+	tree := NewStoreBPlusTree(m.total, store)
+	tree.meta = meta
+	if tree.cmp, err = LookupComparator(m.cmp); err != nil {
+		return nil, err
+	}
+	if m.root == 0 {
+		return tree, nil
+	}
+	var last *bplusNode // the last leaf loaded
+	if tree.root, err = tree.load(m.root, nil, &last); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// load reads the node in page idx, leaves are loaded in order, so each one is
+// linked after last
+func (tree *bplusTree) load(idx int, p *bplusNode, last **bplusNode) (*bplusNode, error) {
+	var buf bytes.Buffer
+	if _, err := tree.store.WriteTo(&buf, idx); err != nil {
+		return nil, err
+	}
+	page, err := decodeNode(buf.Bytes())
+	if err != nil {
+		return nil, &PageError{Index: idx, Err: err}
+	}
+	tree.blocks = append(tree.blocks, idx)
+	n := &bplusNode{p: p, items: make([]Storeable, len(page.elems))}
+	for i, e := range page.elems {
+		n.items[i] = e.data
+	}
+	if page.leaf {
+		if len(page.elems) == 0 {
+			return nil, &PageError{Index: idx, Err: fmt.Errorf("%w: empty leaf", ErrCorrupt)}
+		}
+		n.prev = *last
+		if *last != nil {
+			(*last).next = n
+		}
+		*last = n
+		tree.count += len(n.items)
+		return n, nil
+	}
+	n.children = make([]*bplusNode, len(page.elems)+1)
+	for i := range n.children {
+		child := page.first
+		if i > 0 {
+			child = page.elems[i-1].after
+		}
+		if child == 0 {
+			return nil, &PageError{Index: idx, Err: fmt.Errorf("%w: child %d is missing", ErrCorrupt, i)}
+		}
+		if n.children[i], err = tree.load(child, n, last); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,188 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func scanKeys(it Iterator) []string {
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, string(it.Data().(pair).Key))
+	}
+	return keys
+}
+
+func TestBPlusTree(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewBPlusTree(40)
+		live := map[string]string{}
+		for i := 0; i < 400; i++ {
+			k := fmt.Sprintf("%0*d", 1+r.Intn(3), r.Intn(150))
+			if r.Intn(3) == 0 {
+				if err := tree.Del(SK(k)); (err == nil) != (live[k] != "") {
+					t.Fatalf("seed %d step %d del %s error: %v", seed, i, k, err)
+				}
+				delete(live, k)
+			} else {
+				v := strings.Repeat("v", 1+r.Intn(8))
+				tree.Put(SP(k, v))
+				live[k] = v
+			}
+			if err := tree.Check(); err != nil {
+				t.Fatalf("seed %d step %d: %s", seed, i, err.Error())
+			}
+		}
+		keys := make([]string, 0, len(live))
+		for k, v := range live {
+			keys = append(keys, k)
+			if data, err := tree.Get(SK(k)); err != nil || string(data.(pair).Val) != v {
+				t.Fatalf("seed %d lost %s", seed, k)
+			}
+		}
+		sort.Strings(keys)
+		if got := scanKeys(tree.Scan(nil, nil)); fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Fatalf("seed %d scan\n%v\nshould be\n%v", seed, got, keys)
+		}
+		if tree.Len() != len(keys) {
+			t.Fatalf("seed %d len %d should be %d", seed, tree.Len(), len(keys))
+		}
+	}
+}
+
+func TestBPlusTree_delRebalance(t *testing.T) {
+	tree := NewBPlusTree(64)
+	for i := 0; i < 400; i++ {
+		tree.Put(SP(fmt.Sprintf("%03d", i), "v"))
+	}
+	for i := 0; i < 400; i++ {
+		if i%20 != 0 {
+			tree.Del(SK(fmt.Sprintf("%03d", i)))
+		}
+	}
+	if err := tree.Check(); err != nil {
+		t.Fatalf("check error: %s", err.Error())
+	}
+	n := tree.root
+	for n.children != nil {
+		n = n.children[0]
+	}
+	leaves := 0
+	for ; n != nil; n = n.next {
+		leaves++
+	}
+	if leaves > 3 {
+		t.Fatalf("sparse leaves should be merged, %d leaves hold 20 keys", leaves)
+	}
+	if got := scanKeys(tree.Scan(nil, nil)); len(got) != 20 || got[1] != "020" {
+		t.Fatalf("scan after rebalance error: %v", got)
+	}
+}
+
+func TestBPlusTree_Scan(t *testing.T) {
+	tree := NewBPlusTree(32)
+	for _, item := range sortedItems(100) {
+		tree.Put(item)
+	}
+	if got := scanKeys(tree.Scan(SK("0010"), SK("0013"))); fmt.Sprint(got) != "[0010 0011 0012]" {
+		t.Fatalf("scan [0010, 0013) got %v", got)
+	}
+	if got := scanKeys(tree.Scan(SK("00975"), nil)); fmt.Sprint(got) != "[0098 0099]" {
+		t.Fatalf("scan from 00975 got %v", got)
+	}
+	// the scan goes on from the last element if the tree is changed
+	it := tree.Scan(nil, SK("0006"))
+	it.Next()
+	it.Next()
+	tree.Del(SK("0002"))
+	tree.Put(SP("00015", ""))
+	tree.Del(SK("0003"))
+	rest := scanKeys(it)
+	if fmt.Sprint(rest) != "[00015 0004 0005]" {
+		t.Fatalf("scan after change got %v", rest)
+	}
+	if err := tree.Del(SK("0003")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("del missing should be ErrNotFound")
+	}
+}
+
+func TestBPlusTree_fanout(t *testing.T) {
+	val := bytes.Repeat([]byte("v"), 20)
+	btree, bplus := NewTree(128), NewBPlusTree(128)
+	for i := 0; i < 1000; i++ {
+		data := BP([]byte(fmt.Sprintf("%04d", i)), val)
+		btree.Put(data)
+		bplus.Put(data)
+	}
+	interior := 0
+	for n := bplus.root; n.children != nil; n = n.children[0] {
+		interior++
+	}
+	depth := 0
+	for n := btree.root; n != nil; n = n.first {
+		depth++
+	}
+	if interior+1 >= depth {
+		t.Fatalf("b+tree depth %d should be less than btree depth %d", interior+1, depth)
+	}
+}
+
+func TestBPlusTree_Sync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreBPlusTree(128, s)
+			r := rand.New(rand.NewSource(1))
+			live := map[string]bool{}
+			for round := 0; round < 3; round++ {
+				for i := 0; i < 300; i++ {
+					k := fmt.Sprintf("%04d", r.Intn(500))
+					if r.Intn(4) == 0 {
+						tree.Del(SK(k))
+						delete(live, k)
+					} else {
+						tree.Put(SP(k, k))
+						live[k] = true
+					}
+				}
+				meta, err := tree.Sync()
+				if err != nil {
+					t.Fatalf("sync error: %s", err.Error())
+				}
+				loaded, err := OpenBPlusTree(s, meta)
+				if err != nil {
+					t.Fatalf("open error: %s", err.Error())
+				}
+				if err := loaded.Check(); err != nil {
+					t.Fatalf("round %d: %s", round, err.Error())
+				}
+				keys := make([]string, 0, len(live))
+				for k := range live {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				if got := scanKeys(loaded.Scan(nil, nil)); fmt.Sprint(got) != fmt.Sprint(keys) {
+					t.Fatalf("round %d loaded tree should have the synced keys", round)
+				}
+				if data, err := loaded.Get(SK(keys[0])); err != nil || string(data.(pair).Val) != keys[0] {
+					t.Fatalf("round %d get from loaded tree error", round)
+				}
+				stats, _ := s.Stats()
+				if stats.Used != len(tree.blocks)+1 {
+					t.Fatalf("round %d pages of the last sync should be erased, %d used for %d pages", round, stats.Used, len(tree.blocks))
+				}
+			}
+			if _, err := OpenTree(s, tree.meta); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("open a B+tree as a btree should be ErrCorrupt, got %v", err)
+			}
+			if err := tree.Put(SP(strings.Repeat("k", 60), "v")); !errors.Is(err, ErrValueTooLarge) {
+				t.Fatalf("put an element larger than a node should be ErrValueTooLarge")
+			}
+		})
+	})
+}
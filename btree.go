@@ -4,13 +4,45 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const refSize = 4
+
 type btree struct {
-	root  *node
-	total uint16 // total key bytes
-	lock  sync.RWMutex
+	root     *node
+	total    uint16 // total key bytes
+	lock     sync.RWMutex
+	store    BlockStore
+	overflow int // values longer than overflow are stored in a block chain
+	prefix   bool
+	cmp      Comparator // compares keys of pairs, zero value means bytes.Compare
+	meta     int        // meta block of the tree in store
+	blocks   []int      // node blocks written by the last Sync
+
+	gen       uint64                 // nodes of older generations are shared with snapshots
+	snapshots map[*Snapshot]struct{} // open snapshots
+	released  []int                  // value chains to erase once all snapshots are closed
+	batching  bool                   // a batch is being written
+	pending   []int                  // value chains released by the batch being written
+	changes   []change               // writes of the batch being written, recorded at commit
+
+	seq     uint64 // sequence number of the last write
+	history *btree // versions of keys if versions are enabled
+	pruned  uint64 // versions before pruned are collected
+
+	ttl   *ttlIndex        // deadlines of keys put with a TTL
+	clock func() time.Time // nil means time.Now
+
+	indexes map[string]*Index // secondary indexes by name
+	feed    *changeFeed       // watchers and recent writes
+
+	merger   MergeOperator
+	deltas   *btree // merge operands not combined yet
+	deltaSeq uint64
 }
 
 type Storeable interface {
@@ -26,6 +58,8 @@ type elem struct {
 
 type pair struct {
 	Key, Val []byte
+
+	ref int // first block of the value chain if the value overflows
 }
 
 func BP(key, val []byte) pair {
@@ -45,6 +79,11 @@ func SK(key string) pair {
 }
 
 func (p pair) Bytes(max int) [][]byte {
+	if p.ref != 0 {
+		ref := make([]byte, refSize)
+		binary.BigEndian.PutUint32(ref, uint32(p.ref))
+		return [][]byte{append(append([]byte{}, p.Key...), ref...)}
+	}
 	if len(p.Key)+len(p.Val) > max {
 		return [][]byte{p.Key, p.Val}
 	}
@@ -52,62 +91,354 @@ func (p pair) Bytes(max int) [][]byte {
 }
 
 func (p pair) Size(max int) int {
+	if p.ref != 0 {
+		return len(p.Key) + refSize
+	}
 	return len(p.Key) + len(p.Val)
 }
 
+// Compare orders pairs by key, pairs sort before any other Comparable
 func (p pair) Compare(t Comparable) int {
-	return bytes.Compare(p.Key, t.(pair).Key)
+	if o, ok := t.(pair); ok {
+		return bytes.Compare(p.Key, o.Key)
+	}
+	return -1
 }
 
 func (p elem) Compare(t Comparable) int {
-	return p.data.Compare(t.(elem).data)
+	if o, ok := t.(elem); ok {
+		return p.data.Compare(o.data)
+	}
+	return p.data.Compare(t)
 }
 
 type node struct {
-	elems  array
-	first  *node
-	p      *node
-	block  int
-	synced bool
-	tree   *btree
+	elems array
+	first *node
+	p     *node
+	block int
+	tree  *btree
+	count int64 // elements in the subtree, latched writers change it atomically
+	gen   uint64
+	latch sync.RWMutex
 }
 
 func NewTree(total uint16) *btree {
 	return &btree{total: total}
 }
 
-func (tree *btree) Put(data Storeable) {
+// NewStoreTree creates a tree whose values longer than overflow bytes are
+// stored in block chains of store, only the reference to the chain is kept
+// in the node. overflow <= 0 means the max size of an element in a node
+func NewStoreTree(total uint16, store BlockStore, overflow int) *btree {
+	return &btree{total: total, store: store, overflow: overflow}
+}
+
+// Put puts data in place under node latches if it fits in its node, so puts
+// on different nodes proceed in parallel. Otherwise the tree is locked to
+// split nodes
+func (tree *btree) Put(data Storeable) error {
+	stored, err := tree.spill(data)
+	if err != nil {
+		return err
+	}
+	tree.lock.RLock()
+	done, err := tree.putLatched(stored, data)
+	tree.lock.RUnlock()
+	if done && err == nil {
+		return nil
+	}
 	tree.lock.Lock()
 	defer tree.lock.Unlock()
-	if tree.root == nil {
-		tree.root = &node{elems: []Comparable{elem{data: data}}, tree: tree}
-		return
+	if !done {
+		err = tree.insert(stored, data)
 	}
-	tree.root = tree.root.put(data)
+	if err != nil {
+		tree.unspill(stored)
+	}
+	return err
 }
 
-func (tree *btree) Del(data Storeable) {
+// Del deletes data in place under node latches if it's in a leaf with other
+// elements, otherwise the tree is locked to merge nodes
+func (tree *btree) Del(data Storeable) error {
+	if err := tree.checkKey(data); err != nil {
+		return err
+	}
+	tree.lock.RLock()
+	done, err := tree.delLatched(data)
+	// a key may have operands only
+	merging := tree.deltas != nil
+	tree.lock.RUnlock()
+	if done && !(merging && errors.Is(err, ErrNotFound)) {
+		return err
+	}
 	tree.lock.Lock()
 	defer tree.lock.Unlock()
+	return tree.del(data)
+}
+
+// Get returns data in the tree, an expired key is deleted lazily
+func (tree *btree) Get(data Storeable) (Storeable, error) {
+	if err := tree.checkKey(data); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	ret, err := tree.get(data)
+	tree.lock.RUnlock()
+	if err == errExpired {
+		return tree.expire(data)
+	}
+	return ret, err
+}
+
+func (tree *btree) put(data Storeable) error {
+	stored, err := tree.spill(data)
+	if err != nil {
+		return err
+	}
+	if err = tree.insert(stored, data); err != nil {
+		tree.unspill(stored)
+	}
+	return err
+}
+
+// insert puts stored, which is data with its value spilled
+func (tree *btree) insert(stored, data Storeable) error {
+	if err := tree.checkIndexes(data); err != nil {
+		return err
+	}
+	if tree.root == nil {
+		tree.root = &node{elems: []Comparable{elem{data: stored}}, tree: tree, count: 1, gen: tree.gen}
+		return tree.record(data, false)
+	}
+	old, err := tree.root.get(stored)
+	tree.root = tree.root.put(stored)
+	if err == nil {
+		if err := tree.release(old); err != nil {
+			return err
+		}
+	}
+	return tree.record(data, false)
+}
+
+func (tree *btree) del(data Storeable) error {
+	if tree.root == nil {
+		return tree.delMerged(data)
+	}
+	old, err := tree.root.get(data)
+	if errors.Is(err, ErrNotFound) {
+		return tree.delMerged(data)
+	} else if err != nil {
+		return err
+	}
 	tree.root = tree.root.del(data)
 	if len(tree.root.elems) == 0 {
 		tree.root = tree.root.first
+		if tree.root != nil {
+			tree.root.p = nil
+		}
+	}
+	if err := tree.release(old); err != nil {
+		return err
 	}
+	return tree.record(data, true)
 }
 
-func (tree *btree) Get(data Storeable) (Storeable, error) {
-	tree.lock.RLock()
-	defer tree.lock.RUnlock()
-	return tree.root.get(data)
+func (tree *btree) get(data Storeable) (Storeable, error) {
+	if tree.deltas != nil {
+		return tree.getMerged(data)
+	}
+	if tree.root == nil {
+		return nil, ErrNotFound
+	}
+	ret, err := tree.root.get(data)
+	if err != nil {
+		return nil, err
+	}
+	if tree.expired(ret) {
+		return nil, errExpired
+	}
+	return tree.load(ret)
+}
+
+// SetComparator changes how keys of pairs are ordered, it's only allowed on
+// an empty tree
+func (tree *btree) SetComparator(c Comparator) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root != nil {
+		return fmt.Errorf("%w: tree is not empty", ErrComparator)
+	}
+	tree.cmp = c
+	return nil
+}
+
+// EnablePrefixCompression stores the keys in a node without the prefix they
+// share with the key before, node sizes count the compressed keys, so nodes
+// hold more keys with common prefixes. It's only allowed on an empty tree
+func (tree *btree) EnablePrefixCompression() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root != nil {
+		return ErrNotEmpty
+	}
+	tree.prefix = true
+	return nil
+}
+
+func (tree *btree) Comparator() Comparator {
+	if tree.cmp.Compare == nil {
+		return BytesComparator
+	}
+	return tree.cmp
+}
+
+// checkKey rejects data whose key the comparator can't order
+func (tree *btree) checkKey(data Storeable) error {
+	p, ok := data.(pair)
+	if !ok || tree.cmp.Check == nil {
+		return nil
+	}
+	if err := tree.cmp.Check(p.Key); err != nil {
+		return fmt.Errorf("%w: key %x: %s", ErrComparator, p.Key, err.Error())
+	}
+	return nil
+}
+
+// compare compares elements by the comparator if both of them are pairs
+func (tree *btree) compare(a, b Comparable) int {
+	if tree.cmp.Compare != nil {
+		pa, aok := a.(elem).data.(pair)
+		pb, bok := b.(elem).data.(pair)
+		if aok && bok {
+			return tree.cmp.Compare(pa.Key, pb.Key)
+		}
+	}
+	return a.Compare(b)
+}
+
+func (tree *btree) overflowAt() int {
+	if tree.overflow > 0 {
+		return tree.overflow
+	}
+	return tree.elemMax()
+}
+
+func (tree *btree) elemMax() int {
+	return (int(tree.total) - tree.nodeHead()) / 2
+}
+
+// nodeHead returns the bytes of a node besides its elements, a tree with a
+// store counts the header of its node pages
+func (tree *btree) nodeHead() int {
+	if tree.store != nil {
+		return nodeHeadSize
+	}
+	return 6
+}
+
+// elemHead returns the bytes of an element besides its data, a tree with a
+// store counts the header and the child index of the element in node pages
+func (tree *btree) elemHead() int {
+	if tree.store != nil {
+		return elemHeadSize + 4
+	}
+	return 0
+}
+
+// spill moves the value of data into a block chain if it overflows, data
+// which still doesn't fit in a node is rejected. Without a store values are
+// never spilled
+func (tree *btree) spill(data Storeable) (Storeable, error) {
+	if err := tree.checkKey(data); err != nil {
+		return nil, err
+	}
+	max := tree.elemMax()
+	size := tree.elemHead() + data.Size(max)
+	p, ok := data.(pair)
+	if !ok || tree.store == nil || (len(p.Val) <= tree.overflowAt() && size <= max) {
+		if size > max {
+			return nil, fmt.Errorf("%w: %d bytes doesn't fit in a node of %d", ErrValueTooLarge, size, tree.total)
+		}
+		return data, nil
+	}
+	stored := pair{Key: p.Key, ref: -1}
+	if size := tree.elemHead() + stored.Size(max); size > max {
+		return nil, fmt.Errorf("%w: key of %d bytes doesn't fit in a node of %d", ErrValueTooLarge, size, tree.total)
+	}
+	blocks, err := storeValue(tree.store, p.Val, CodecNone)
+	if err != nil {
+		return nil, err
+	}
+	stored.ref = blocks[0].Index()
+	return stored, nil
+}
+
+// fits rejects data which doesn't fit in a node of the memory tree
+func (tree *btree) fits(data Storeable) error {
+	max := tree.elemMax()
+	if size := data.Size(max); size > max {
+		return fmt.Errorf("%w: %d bytes doesn't fit in a node of %d", ErrValueTooLarge, size, tree.total)
+	}
+	return nil
+}
+
+// unspill erases the block chain of stored if its write failed before it was
+// put in the tree
+func (tree *btree) unspill(stored Storeable) error {
+	p, ok := stored.(pair)
+	if !ok || p.ref == 0 {
+		return nil
+	}
+	if tree.root != nil {
+		if found, err := tree.root.get(stored); err == nil && found.(pair).ref == p.ref {
+			return nil
+		}
+	}
+	return eraseChain(tree.store, p.ref)
+}
+
+// load reads the value of data back from its block chain
+func (tree *btree) load(data Storeable) (Storeable, error) {
+	p, ok := data.(pair)
+	if !ok || p.ref == 0 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if _, err := tree.store.WriteTo(&buf, p.ref); err != nil {
+		return nil, err
+	}
+	return pair{Key: p.Key, Val: buf.Bytes()}, nil
+}
+
+// release erases the block chain of data
+func (tree *btree) release(data Storeable) error {
+	p, ok := data.(pair)
+	if !ok || p.ref == 0 {
+		return nil
+	}
+	if tree.batching {
+		// the batch may be reverted
+		tree.pending = append(tree.pending, p.ref)
+		return nil
+	}
+	if len(tree.snapshots) > 0 {
+		tree.released = append(tree.released, p.ref)
+		return nil
+	}
+	return eraseChain(tree.store, p.ref)
 }
 
 func (n *node) put(data Storeable) *node {
 	p := elem{data: data}
-	pos, exactly := n.elems.shouldBe(p)
+	pos, exactly := n.shouldBe(p)
 	if exactly {
+		n = n.own()
 		p.after = n.elems[pos].(elem).after
 		n.elems[pos] = p
-		return n.root()
+		// the new value may be longer
+		return n.popup()
 	}
 	if pos == 0 {
 		if n.first != nil {
@@ -116,36 +447,52 @@ func (n *node) put(data Storeable) *node {
 	} else if n.elems[pos-1].(elem).after != nil {
 		return n.elems[pos-1].(elem).after.put(data)
 	}
+	n = n.own()
 	n.elems.insertAt(pos, p)
 	return n.popup()
 }
 
 func (n *node) del(data Storeable) *node {
-	p := elem{data: data}
-	pos, exactly := n.elems.shouldBe(p)
+	pos, exactly := n.shouldBe(elem{data: data})
 	if !exactly {
-		if pos == 0 {
-			if n.first != nil {
-				return n.first.del(data)
-			}
-		} else {
-			if c := n.elems[pos-1].(elem).after; c != nil {
-				return c.del(data)
-			}
+		if c := n.child(pos); c != nil {
+			return c.del(data)
 		}
+		return n.root()
 	}
+	n = n.own()
 	if n.leaf() {
-		return n.delLeaf(pos)
+		return n.delAt(pos)
+	}
+	// replace with the predecessor, then delete the predecessor from its leaf
+	leaf := n.child(pos).rightmost().own()
+	last := len(leaf.elems) - 1
+	p := n.elems[pos].(elem)
+	p.data = leaf.elems[last].(elem).data
+	n.elems[pos] = p
+	// the predecessor may be longer than the deleted element
+	root := leaf.delAt(last)
+	if holder := root.holder(p.data); holder.overflow() {
+		return holder.popup()
 	}
-	return n.delNonLeaf(pos)
+	return root
 }
 
-func (n *node) delNonLeaf(pos int) *node {
-	p := n.elems[pos].(elem)
+// holder returns the node which holds data
+func (n *node) holder(data Storeable) *node {
+	pos, exactly := n.shouldBe(elem{data: data})
+	if exactly {
+		return n
+	}
+	return n.child(pos).holder(data)
+}
+
+func (n *node) delAt(pos int) *node {
 	n.elems = append(n.elems[:pos], n.elems[pos+1:]...)
 	if len(n.elems) == 0 {
-		return n.merge(p)
+		return n.merge()
 	}
+	n.recountUp()
 	return n.root()
 }
 
@@ -161,93 +508,238 @@ func (n *node) leaf() bool {
 	return true
 }
 
-func (n *node) delLeaf(pos int) *node {
-	p := n.elems[pos].(elem)
-	n.elems = append(n.elems[:pos], n.elems[pos+1:]...)
-	if len(n.elems) != 0 {
-		return n.root()
+// merge fixes the empty node n, it borrows an element from a sibling with
+// more than one element through the parent, or merges n into a sibling
+func (n *node) merge() *node {
+	if n.p == nil {
+		n.recount()
+		return n
 	}
-	return n.merge(p)
+	ci := n.index()
+	var left, right *node
+	if ci > 0 {
+		left = n.p.child(ci - 1).own()
+	}
+	if ci < len(n.p.elems) {
+		right = n.p.child(ci + 1).own()
+	}
+	switch {
+	case left != nil && len(left.elems) > 1:
+		n.borrowLeft(left, ci)
+	case right != nil && len(right.elems) > 1:
+		n.borrowRight(right, ci)
+	case left != nil:
+		return n.mergeLeft(left, ci)
+	default:
+		return n.mergeRight(right, ci)
+	}
+	// the separator borrowed from the sibling may be longer
+	n.recount()
+	return n.p.popup()
 }
 
-func (n *node) mergeRight(pos int, p elem) *node {
-	np := n.p.elems[pos].(elem)
-	if np.after != nil {
-		after := np.after
-		np.after = after.first
-		if after.first != nil {
-			after.first.p = n
-		}
-		n.elems = append([]Comparable{np}, after.elems...)
+func (n *node) borrowLeft(left *node, ci int) {
+	np := n.p.elems[ci-1].(elem)
+	last := left.elems[len(left.elems)-1].(elem)
+	n.elems = array{elem{data: np.data, after: n.first}}
+	n.first = last.after
+	if n.first != nil {
+		n.first.p = n
 	}
-	if p.after != nil {
-		if n.first != nil {
-			p.after.elems = append(n.first.elems, p.after.elems...)
-		}
-		n.first = p.after
+	n.p.elems[ci-1] = elem{data: last.data, after: n}
+	left.elems = left.elems[:len(left.elems)-1]
+	left.recount()
+}
+
+func (n *node) borrowRight(right *node, ci int) {
+	np := n.p.elems[ci].(elem)
+	first := right.elems[0].(elem)
+	n.elems = array{elem{data: np.data, after: right.first}}
+	if right.first != nil {
+		right.first.p = n
 	}
-	n.p.elems = append(n.p.elems[:pos], n.p.elems[pos+1:]...)
-	n.popup()
-	if len(n.p.elems) == 0 && n.p.p != nil {
-		return n.p.merge(np)
+	n.p.elems[ci] = elem{data: first.data, after: right}
+	right.first = first.after
+	right.elems = right.elems[1:]
+	right.recount()
+}
+
+func (n *node) mergeLeft(left *node, ci int) *node {
+	np := n.p.elems[ci-1].(elem)
+	left.elems = append(left.elems, elem{data: np.data, after: n.first})
+	if n.first != nil {
+		n.first.p = left
 	}
-	return n.root()
+	left.recount()
+	p := n.p
+	p.elems = append(p.elems[:ci-1], p.elems[ci:]...)
+	if left.overflow() {
+		return left.popup()
+	}
+	if len(p.elems) == 0 {
+		return p.merge()
+	}
+	p.recountUp()
+	return p.root()
 }
 
-func (n *node) mergeLeft(pos int, p elem) *node {
-	pos -= 1
-	np := n.p.elems[pos].(elem)
-	if p.after == nil && n.first != nil && np.Compare(n.first.elems[0]) < 0 {
-		p.after = n.first
-		n.first = nil
-	}
-	p.data = np.data
-	n.elems = []Comparable{p}
-	n.p.elems = append(n.p.elems[:pos], n.p.elems[pos+1:]...)
-	ppos := pos - 1
-	if ppos == -1 && n.p.first != nil {
-		n.elems = append(n.p.first.elems, n.elems...)
-		n.first = n.p.first.first
-		n.p.first = n
-	} else if bnp := n.p.elems[ppos].(elem); bnp.after != nil {
-		n.elems = append(bnp.after.elems, n.elems...)
-		bnp.after = n
-		n.p.elems[ppos] = bnp
-	}
-	n.popup()
-	if len(n.p.elems) == 0 && n.p.p != nil {
-		return n.p.merge(np)
+func (n *node) mergeRight(right *node, ci int) *node {
+	np := n.p.elems[ci].(elem)
+	right.elems = append(array{elem{data: np.data, after: right.first}}, right.elems...)
+	right.first = n.first
+	if n.first != nil {
+		n.first.p = right
 	}
-	return n.root()
+	right.recount()
+	p := n.p
+	p.elems = append(p.elems[:ci], p.elems[ci+1:]...)
+	p.setChild(ci, right)
+	if right.overflow() {
+		return right.popup()
+	}
+	if len(p.elems) == 0 {
+		return p.merge()
+	}
+	p.recountUp()
+	return p.root()
 }
 
-func (n *node) merge(p elem) *node {
-	if n.p == nil {
+// child returns the ith child, the first child is n.first and the (i+1)th
+// child is the after of the ith element
+func (n *node) child(i int) *node {
+	if i == 0 {
+		return n.first
+	}
+	return n.elems[i-1].(elem).after
+}
+
+func (n *node) setChild(i int, c *node) {
+	if i == 0 {
+		n.first = c
+		return
+	}
+	p := n.elems[i-1].(elem)
+	p.after = c
+	n.elems[i-1] = p
+}
+
+// index returns the position of n in the children of its parent
+func (n *node) index() int {
+	for i := 0; i <= len(n.p.elems); i++ {
+		if n.p.child(i) == n {
+			return i
+		}
+	}
+	return -1
+}
+
+// own returns n if it can be changed in place, a node shared with snapshots
+// is copied into the current generation first, along with its ancestors
+func (n *node) own() *node {
+	tree := n.tree
+	if n.gen == tree.gen {
 		return n
 	}
-	pos, _ := n.p.elems.shouldBe(p)
-	if pos == 0 {
-		return n.mergeRight(pos, p)
+	if len(tree.snapshots) == 0 {
+		n.gen = tree.gen
+		return n
+	}
+	c := &node{elems: append(array{}, n.elems...), first: n.first, tree: tree, count: n.count, gen: tree.gen}
+	if n.p == nil {
+		tree.root = c
+	} else {
+		c.p = n.p.own()
+		c.p.setChild(n.index(), c)
+	}
+	c.adopt()
+	return c
+}
+
+func (n *node) rightmost() *node {
+	if c := n.child(len(n.elems)); c != nil {
+		return c.rightmost()
+	}
+	return n
+}
+
+func (n *node) leftmost() *node {
+	if n.first != nil {
+		return n.first.leftmost()
+	}
+	return n
+}
+
+func (n *node) recount() {
+	count := int64(len(n.elems))
+	if n.first != nil {
+		count += n.first.count
+	}
+	for _, p := range n.elems {
+		if after := p.(elem).after; after != nil {
+			count += after.count
+		}
+	}
+	atomic.StoreInt64(&n.count, count)
+}
+
+// len returns the number of elements in the subtree
+func (n *node) len() int {
+	return int(atomic.LoadInt64(&n.count))
+}
+
+func (n *node) recountUp() {
+	for c := n; c != nil; c = c.p {
+		c.recount()
 	}
-	return n.mergeLeft(pos, p)
 }
 
 func (n *node) popup() *node {
 	if !n.overflow() {
+		n.recountUp()
 		return n.root()
 	}
-	nn, p := n.split(len(n.elems) / 2)
+	nn, p := n.split(n.middle())
+	n.recount()
+	nn.recount()
 	if n.p == nil {
-		n.p = &node{first: n, elems: []Comparable{p}, tree: n.tree}
+		n.p = &node{first: n, elems: []Comparable{p}, tree: n.tree, gen: n.gen}
 		nn.p = n.p
+		n.p.recount()
 		return n.p
 	}
 	nn.p = n.p
-	pos, _ := n.p.elems.shouldBe(p)
+	pos, _ := n.p.shouldBe(p)
 	n.p.elems.insertAt(pos, p)
 	return n.p.popup()
 }
 
+// middle returns the element to split n at, the larger half uses as few
+// bytes as possible, so both halves fit when elements vary in size
+func (n *node) middle() int {
+	max := n.elemMax()
+	sizes := make([]int, len(n.elems))
+	total := 0
+	for i := range n.elems {
+		sizes[i] = n.elemSize(i)
+		total += sizes[i]
+	}
+	best, bestUse := 1, -1
+	left := sizes[0]
+	for pos := 1; pos <= len(n.elems)-2; pos++ {
+		// the first element after pos loses the prefix it shares with pos
+		right := total - left - sizes[pos] + n.tree.elemHead() + n.elems[pos+1].(elem).data.Size(max) - sizes[pos+1]
+		use := left
+		if right > use {
+			use = right
+		}
+		if bestUse == -1 || use <= bestUse {
+			best, bestUse = pos, use
+		}
+		left += sizes[pos]
+	}
+	return best
+}
+
 func (n *node) split(pos int) (nn *node, p elem) {
 	p = n.elems[pos].(elem)
 	nd := make(array, pos)
@@ -259,7 +751,7 @@ func (n *node) split(pos int) (nn *node, p elem) {
 	nnd := make(array, r)
 	copy(nnd, n.elems[pos+1:])
 	n.elems = nd
-	nn = &node{elems: nnd, tree: n.tree}
+	nn = &node{elems: nnd, tree: n.tree, gen: n.gen}
 	for i, p := range nn.elems {
 		if p.(elem).after != nil {
 			p.(elem).after.p = nn
@@ -274,27 +766,14 @@ func (n *node) split(pos int) (nn *node, p elem) {
 	return
 }
 
-func (n *node) get(data Storeable) (ret Storeable, err error) {
-	pos, exactly := n.elems.shouldBe(elem{data: data})
-	if exactly {
-		ret = n.elems[pos].(elem).data
-		return
-	}
-	if pos == 0 {
-		if n.first != nil {
-			ret, err = n.first.get(data)
-			return
-		}
-		err = errors.New("not found")
-		return
-	}
-	child := n.elems[pos-1].(elem).after
-	if child == nil {
-		err = errors.New("not found")
-		return
+func (n *node) get(data Storeable) (Storeable, error) {
+	holder, _ := n.descend(data)
+	defer holder.latch.RUnlock()
+	pos, exactly := holder.shouldBe(elem{data: data})
+	if !exactly {
+		return nil, ErrNotFound
 	}
-	ret, err = child.get(data)
-	return
+	return holder.elems[pos].(elem).data, nil
 }
 
 func (n *node) overflow() bool {
@@ -302,58 +781,63 @@ func (n *node) overflow() bool {
 }
 
 func (n *node) shouldUse() int {
-	t := 6
-	for _, p := range n.elems {
-		t += p.(elem).data.Size(n.elemMax())
+	t := n.tree.nodeHead()
+	for i := range n.elems {
+		t += n.elemSize(i)
 	}
 	return t
 }
 
+// elemSize returns the bytes of the ith element, with prefix compression the
+// prefix its key shares with the key before isn't counted
+func (n *node) elemSize(i int) int {
+	data := n.elems[i].(elem).data
+	size := n.tree.elemHead() + data.Size(n.elemMax())
+	if n.tree.prefix && i > 0 {
+		size -= sharedKey(n.elems[i-1].(elem).data, data)
+	}
+	return size
+}
+
 func (n *node) elemMax() int {
-	return int((n.tree.total - 6) / 2)
+	return n.tree.elemMax()
 }
 
-func (n *node) sync(store *blockStore) error {
-	if n.synced {
-		return nil
-	}
-	bs := make([]byte, n.tree.total)
-	first := 0
+// sync writes n and its children into new block chains of store
+func (n *node) sync(store BlockStore) error {
 	if n.first != nil {
-		n.first.sync(store)
-		first = n.first.block
+		if err := n.first.sync(store); err != nil {
+			return err
+		}
 	}
-	binary.BigEndian.PutUint32(bs[:4], uint32(first))
-	binary.BigEndian.PutUint16(bs[4:6], uint16(n.tree.total))
-	pos := 6
 	for _, p := range n.elems {
-		el := p.(elem).data
-		size := el.Size(n.elemMax())
-		copy(bs[pos:pos+size], el.Bytes(n.elemMax())[0])
-		pos += size
-		after := 0
-		if p.(elem).after != nil {
-			p.(elem).after.sync(store)
-			after = p.(elem).after.block
+		if after := p.(elem).after; after != nil {
+			if err := after.sync(store); err != nil {
+				return err
+			}
 		}
-		binary.BigEndian.PutUint32(bs[pos:pos+4], uint32(after))
-		pos += 4
 	}
-	if n.block == 0 {
-		blocks, err := store.Acquire(1)
-		if err != nil {
-			return err
-		}
-		blocks[0].Data = bs
-		return store.Put(blocks)
+	return n.write(store)
+}
+
+// write puts the page of n into store, the pages of its children are written
+// already
+func (n *node) write(store BlockStore) error {
+	page, err := n.page()
+	if err != nil {
+		return err
+	}
+	blocks, err := storeValue(store, encodeNode(page), CodecNone)
+	if err != nil {
+		return err
 	}
-	block := Block{Type: TypeSingle, Data: bs, Next: 0, size: uint16(pos), idx: n.block}
-	return store.Put([]Block{block})
+	n.block = blocks[0].Index()
+	n.tree.blocks = append(n.tree.blocks, n.block)
+	return nil
 }
 
-func (n *node) set(do func()) {
-	do()
-	n.synced = false
+func (n *node) shouldBe(p elem) (int, bool) {
+	return n.elems.shouldBeFunc(p, n.tree.compare)
 }
 
 func (n *node) root() *node {
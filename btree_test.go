@@ -2,7 +2,9 @@ package inf
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math/rand"
 	"strings"
 	"testing"
 )
@@ -34,6 +36,19 @@ func printv(tree *btree, key string, t *testing.T) {
 	fmt.Printf("%s\n", val.(pair).Val)
 }
 
+func TestPair_Compare(t *testing.T) {
+	a, b := pair{Key: []byte("a")}, pair{Key: []byte("b")}
+	if a.Compare(b) >= 0 || b.Compare(a) <= 0 || a.Compare(a) != 0 {
+		t.Fatalf("pairs should be ordered by key")
+	}
+	if a.Compare(cbytes("a")) >= 0 || (elem{data: a}).Compare(cbytes("a")) >= 0 {
+		t.Fatalf("pairs should sort before other comparables")
+	}
+	if (elem{data: a}).Compare(elem{data: b}) >= 0 {
+		t.Fatalf("elems should be ordered by data")
+	}
+}
+
 func TestNode_put(t *testing.T) {
 	tree := createTree()
 	isNode("root", tree.root, []string{"04"}, t)
@@ -46,6 +61,16 @@ func TestNode_put(t *testing.T) {
 	isNode("level 2 left 3", tree.root.elems[0].(elem).after.elems[1].(elem).after, []string{"09", "10"}, t)
 }
 
+func TestNode_get(t *testing.T) {
+	tree := createTree()
+	if val, err := tree.Get(SK("05")); err != nil || string(val.(pair).Val) != "05" {
+		t.Fatalf("get 05 error")
+	}
+	if _, err := tree.Get(SK("11")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get 11 should be ErrNotFound")
+	}
+}
+
 func TestNode_del(t *testing.T) {
 	tree := createTree()
 	tree.Del(SK("00"))
@@ -131,3 +156,143 @@ func TestNode_del(t *testing.T) {
 // 		tree.Put(SP(k, k))
 // 	}
 // }
+
+// depth returns the depth of the leaves under n, or -1 if they differ or a
+// child has a wrong parent
+func depth(n *node) int {
+	if n.leaf() {
+		return 1
+	}
+	d := -1
+	for i := 0; i <= len(n.elems); i++ {
+		c := n.child(i)
+		if c == nil || c.p != n || len(c.elems) == 0 {
+			return -1
+		}
+		cd := depth(c)
+		if cd == -1 || (d != -1 && cd != d) {
+			return -1
+		}
+		d = cd
+	}
+	return d + 1
+}
+
+func TestNode_delRebalance(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(32)
+		keys := map[string]bool{}
+		for i := 0; i < 300; i++ {
+			k := fmt.Sprintf("%03d", r.Intn(100))
+			if r.Intn(3) == 0 {
+				if err := tree.Del(SK(k)); (err == nil) != keys[k] {
+					t.Fatalf("seed %d del %s error: %v", seed, k, err)
+				}
+				delete(keys, k)
+			} else {
+				tree.Put(SP(k, k))
+				keys[k] = true
+			}
+			if tree.root != nil && (tree.root.p != nil || depth(tree.root) == -1) {
+				t.Fatalf("seed %d step %d: leaves should be at the same depth", seed, i)
+			}
+			for j := 0; j < 100; j++ {
+				k := fmt.Sprintf("%03d", j)
+				if _, err := tree.Get(SK(k)); (err == nil) != keys[k] {
+					t.Fatalf("seed %d step %d: get %s error: %v", seed, i, k, err)
+				}
+			}
+		}
+	}
+}
+
+func TestTree_overflow(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 16)
+			big := bytes.Repeat([]byte("big value "), 200)
+			if err := tree.Put(BP([]byte("big"), big)); err != nil {
+				t.Fatalf("put big value error: %s", err.Error())
+			}
+			tree.Put(SP("small", "small"))
+			if tree.root.elems[0].(elem).data.(pair).ref == 0 {
+				t.Fatalf("big value should be stored in a block chain")
+			}
+			val, err := tree.Get(SK("big"))
+			if err != nil || !bytes.Equal(val.(pair).Val, big) {
+				t.Fatalf("get big value error")
+			}
+			if err := tree.Put(SP("big", "now small")); err != nil {
+				t.Fatalf("overwrite big value error: %s", err.Error())
+			}
+			if stats, _ := s.Stats(); stats.Used != 0 {
+				t.Fatalf("chain of overwritten value should be erased")
+			}
+			tree.Put(BP([]byte("big"), big))
+			if err := tree.Del(SK("big")); err != nil {
+				t.Fatalf("del big value error: %s", err.Error())
+			}
+			if stats, _ := s.Stats(); stats.Used != 0 {
+				t.Fatalf("chain of deleted value should be erased")
+			}
+			if err := tree.Del(SK("big")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("del missing key should be ErrNotFound")
+			}
+		})
+	})
+}
+
+func TestTree_tooLarge(t *testing.T) {
+	tree := NewTree(64)
+	if err := tree.Put(BP([]byte("big"), bytes.Repeat([]byte("v"), 100))); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("value too large for a node should be ErrValueTooLarge, got %v", err)
+	}
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			if err := tree.Put(BP(bytes.Repeat([]byte("k"), 100), nil)); !errors.Is(err, ErrValueTooLarge) {
+				t.Fatalf("key too large for a node should be ErrValueTooLarge, got %v", err)
+			}
+			long := bytes.Repeat([]byte("v"), 100)
+			tree.Put(BP([]byte("a"), long))
+			stored, _ := tree.spill(BP([]byte("b"), long))
+			if err := tree.unspill(stored); err != nil {
+				t.Fatalf("unspill error: %s", err.Error())
+			}
+			placed, _ := tree.root.get(SK("a"))
+			if err := tree.unspill(placed); err != nil {
+				t.Fatalf("unspill error: %s", err.Error())
+			}
+			if val, err := tree.Get(SK("a")); err != nil || !bytes.Equal(val.(pair).Val, long) {
+				t.Fatalf("chain in the tree should be kept")
+			}
+			if stats, _ := s.Stats(); stats.Used != 1 {
+				t.Fatalf("chain failed to put should be erased, %d blocks used", stats.Used)
+			}
+		})
+	})
+}
+
+func TestNode_resize(t *testing.T) {
+	tree := NewTree(64)
+	for i := 0; i < 50; i++ {
+		tree.Put(SP(fmt.Sprintf("%03d", i), ""))
+	}
+	// longer values split the nodes holding them
+	for i := 0; i < 50; i += 3 {
+		tree.Put(SP(fmt.Sprintf("%03d", i), strings.Repeat("v", 12)))
+		if err := tree.Check(); err != nil {
+			t.Fatalf("overwrite %d: %v", i, err)
+		}
+	}
+	// interior elements are replaced by longer predecessors
+	for tree.root != nil && !tree.root.leaf() {
+		if err := tree.Del(tree.root.elems[0].(elem).data); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Check(); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+}
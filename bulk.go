@@ -0,0 +1,162 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotEmpty    = errors.New("tree is not empty")
+	ErrNotSorted   = errors.New("input is not sorted")
+	ErrInvalidFill = errors.New("fill is not in (0, 1]")
+)
+
+// BulkLoad builds an empty tree from the strictly ascending items of it, nodes
+// are filled bottom up to fill (0, 1] of their capacity instead of splitting
+// again and again. Nodes of a tree with a store are written into blocks as
+// soon as they are built, the tree is synced once the root is built. Like
+// every tree, the built nodes are kept in memory as well. If it fails, the
+// tree is left empty
+func (tree *btree) BulkLoad(it Iterator, fill float64) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root != nil || (tree.deltas != nil && tree.deltas.root != nil) {
+		return ErrNotEmpty
+	}
+	if fill <= 0 || fill > 1 {
+		return fmt.Errorf("%w: %v", ErrInvalidFill, fill)
+	}
+	items, loaded := []Storeable{}, []Storeable{}
+	// chains of the spilled values are erased if the load fails
+	fail := func(err error) error {
+		for _, data := range items {
+			tree.unspill(data)
+		}
+		return err
+	}
+	var last Storeable
+	for it.Next() {
+		data := it.Data()
+		if last != nil && tree.compare(elem{data: last}, elem{data: data}) >= 0 {
+			return fail(fmt.Errorf("%w: %v is not after %v", ErrNotSorted, data, last))
+		}
+		if err := tree.checkIndexes(data); err != nil {
+			return fail(err)
+		}
+		stored, err := tree.spill(data)
+		if err != nil {
+			return fail(err)
+		}
+		// loaded pairs are recorded like puts
+		loaded = append(loaded, data)
+		items, last = append(items, stored), data
+	}
+	if err := it.Err(); err != nil {
+		return fail(err)
+	}
+	old := tree.blocks
+	if tree.store != nil {
+		tree.blocks = nil
+	}
+	var root *node
+	if len(items) > 0 {
+		var err error
+		if root, err = tree.build(items, int(fill*float64(tree.total))); err != nil {
+			return fail(tree.unsync(old, err))
+		}
+	}
+	tree.root = root
+	for _, data := range loaded {
+		if err := tree.record(data, false); err != nil {
+			return err
+		}
+	}
+	if tree.store == nil {
+		return nil
+	}
+	_, err := tree.syncRoot(old)
+	return err
+}
+
+// build packs items into leaves, the items between the leaves are packed into
+// the level above, until the items of a level fit in one node. Every node is
+// written into the store of the tree after its children
+func (tree *btree) build(items []Storeable, limit int) (*node, error) {
+	children := make([]*node, len(items)+1)
+	for {
+		groups, seps := tree.pack(items, limit)
+		nodes := make([]*node, len(groups))
+		for g, r := range groups {
+			n := &node{tree: tree, first: children[r[0]], elems: make(array, 0, r[1]-r[0]), gen: tree.gen}
+			for i := r[0]; i < r[1]; i++ {
+				n.elems = append(n.elems, elem{data: items[i], after: children[i+1]})
+			}
+			n.adopt()
+			n.recount()
+			if tree.store != nil {
+				if err := n.write(tree.store); err != nil {
+					return nil, err
+				}
+			}
+			nodes[g] = n
+		}
+		if len(nodes) == 1 {
+			return nodes[0], nil
+		}
+		upper := make([]Storeable, len(seps))
+		for i, sep := range seps {
+			upper[i] = items[sep]
+		}
+		items, children = upper, nodes
+	}
+}
+
+// pack splits items into groups filled up to limit, the item between two
+// groups is a separator. groups are ranges [start, end) of items
+func (tree *btree) pack(items []Storeable, limit int) (groups [][2]int, seps []int) {
+	max := tree.elemMax()
+	start, used := 0, tree.nodeHead()
+	for i := 0; i < len(items); i++ {
+		size := tree.elemHead() + items[i].Size(max)
+		if i == start || used+size <= limit {
+			used += size
+			continue
+		}
+		groups = append(groups, [2]int{start, i})
+		seps = append(seps, i)
+		start, used = i+1, tree.nodeHead()
+	}
+	if start < len(items) {
+		return append(groups, [2]int{start, len(items)}), seps
+	}
+	// the last item is a separator without the group after it
+	last := len(groups) - 1
+	seps = seps[:len(seps)-1]
+	groups[last][1]++
+	if r := groups[last]; r[1]-r[0] > 2 && tree.usage(items[r[0]:r[1]]) > int(tree.total) {
+		seps = append(seps, r[1]-2)
+		groups[last][1] = r[1] - 2
+		groups = append(groups, [2]int{r[1] - 1, r[1]})
+	}
+	return
+}
+
+func (tree *btree) usage(items []Storeable) int {
+	used := tree.nodeHead()
+	for _, item := range items {
+		used += tree.elemHead() + item.Size(tree.elemMax())
+	}
+	return used
+}
+
+// adopt sets n as the parent of its children
+func (n *node) adopt() {
+	if n.first != nil {
+		n.first.p = n
+	}
+	for _, p := range n.elems {
+		if after := p.(elem).after; after != nil {
+			after.p = n
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func sortedItems(count int) []Storeable {
+	items := make([]Storeable, count)
+	for i := range items {
+		k := fmt.Sprintf("%04d", i)
+		items[i] = SP(k, k)
+	}
+	return items
+}
+
+func countNodes(n *node) int {
+	if n == nil {
+		return 0
+	}
+	c := 1 + countNodes(n.first)
+	for _, p := range n.elems {
+		c += countNodes(p.(elem).after)
+	}
+	return c
+}
+
+func TestTree_BulkLoad(t *testing.T) {
+	for count := 0; count < 60; count++ {
+		for _, fill := range []float64{0.5, 0.8, 1} {
+			tree := NewTree(32)
+			if err := tree.BulkLoad(SliceIterator(sortedItems(count)), fill); err != nil {
+				t.Fatalf("bulk load %d error: %s", count, err.Error())
+			}
+			for _, item := range sortedItems(count) {
+				if _, err := tree.Get(item); err != nil {
+					t.Fatalf("bulk load %d at %v lost %s", count, fill, item.(pair).Key)
+				}
+			}
+			keys := 0
+			walkKeys(tree.root, func(key []byte) {
+				keys++
+			})
+			if keys != count {
+				t.Fatalf("bulk load %d at %v got %d keys", count, fill, keys)
+			}
+			tree.Put(SP("9999", "9999"))
+			if _, err := tree.Get(SK("9999")); err != nil {
+				t.Fatalf("put after bulk load %d at %v error", count, fill)
+			}
+		}
+	}
+	loaded, inserted := NewTree(64), NewTree(64)
+	loaded.BulkLoad(SliceIterator(sortedItems(1000)), 1)
+	for _, item := range sortedItems(1000) {
+		inserted.Put(item)
+	}
+	if countNodes(loaded.root) >= countNodes(inserted.root) {
+		t.Fatalf("bulk loaded tree should use less nodes")
+	}
+}
+
+func TestTree_BulkLoadErrors(t *testing.T) {
+	tree := NewTree(32)
+	if err := tree.BulkLoad(SliceIterator([]Storeable{SK("b"), SK("a")}), 1); !errors.Is(err, ErrNotSorted) {
+		t.Fatalf("bulk load unsorted input should be ErrNotSorted")
+	}
+	if err := tree.BulkLoad(SliceIterator(sortedItems(3)), 1.5); !errors.Is(err, ErrInvalidFill) {
+		t.Fatalf("bulk load with fill 1.5 should be ErrInvalidFill")
+	}
+	tree = createTree()
+	if err := tree.BulkLoad(SliceIterator(sortedItems(3)), 1); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("bulk load into non empty tree should be ErrNotEmpty")
+	}
+}
+
+func TestTree_BulkLoadErase(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			before, _ := s.Stats()
+			long := bytes.Repeat([]byte("v"), 50)
+			items := []Storeable{BP([]byte("a"), long), BP([]byte("c"), long), BP([]byte("b"), long)}
+			if err := tree.BulkLoad(SliceIterator(items), 1); !errors.Is(err, ErrNotSorted) {
+				t.Fatalf("bulk load unsorted input should be ErrNotSorted")
+			}
+			if after, _ := s.Stats(); after.Used != before.Used {
+				t.Fatalf("failed bulk load should erase the spilled values, %d blocks used, %d before", after.Used, before.Used)
+			}
+			if tree.Len() != 0 {
+				t.Fatalf("failed bulk load should leave the tree empty")
+			}
+		})
+	})
+}
+
+func TestTree_BulkLoadSync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(128, s, 0)
+			if err := tree.BulkLoad(SliceIterator(sortedItems(500)), 0.9); err != nil {
+				t.Fatalf("bulk load error: %s", err.Error())
+			}
+			loaded, err := OpenTree(s, tree.meta)
+			if err != nil {
+				t.Fatalf("open tree error: %s", err.Error())
+			}
+			if val, err := loaded.Get(SK("0321")); err != nil || string(val.(pair).Val) != "0321" {
+				t.Fatalf("bulk loaded tree should be synced")
+			}
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package inf
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Check verifies the invariants of the tree: elements are ordered, parent
+// pointers are right, all leaves are at the same depth, no node is empty or
+// overflows, and subtree counts are right. The first violation is returned
+// as ErrCorrupt
+func (tree *btree) Check() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root == nil {
+		return nil
+	}
+	if tree.root.p != nil {
+		return fmt.Errorf("%w: root has parent", ErrCorrupt)
+	}
+	c := &checker{tree: tree, depth: -1}
+	return c.check(tree.root, "root", 0)
+}
+
+type checker struct {
+	tree  *btree
+	last  Comparable
+	depth int // depth of leaves
+}
+
+func (c *checker) check(n *node, path string, depth int) error {
+	if n.tree != c.tree {
+		return fmt.Errorf("%w: %s belongs to another tree", ErrCorrupt, path)
+	}
+	if len(n.elems) == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrCorrupt, path)
+	}
+	if n.overflow() && len(n.elems) > 1 {
+		return fmt.Errorf("%w: %s overflows, uses %d of %d", ErrCorrupt, path, n.shouldUse(), c.tree.total)
+	}
+	count := len(n.elems)
+	for i := 0; i <= len(n.elems); i++ {
+		child := n.child(i)
+		if n.leaf() {
+			if c.depth == -1 {
+				c.depth = depth
+			} else if c.depth != depth {
+				return fmt.Errorf("%w: %s is a leaf at depth %d, others at %d", ErrCorrupt, path, depth, c.depth)
+			}
+		} else if child == nil {
+			return fmt.Errorf("%w: %s misses child %d", ErrCorrupt, path, i)
+		}
+		if child != nil {
+			if child.p != n {
+				return fmt.Errorf("%w: parent of %s/%d is wrong", ErrCorrupt, path, i)
+			}
+			if err := c.check(child, fmt.Sprintf("%s/%d", path, i), depth+1); err != nil {
+				return err
+			}
+			count += child.len()
+		}
+		if i == len(n.elems) {
+			break
+		}
+		p := n.elems[i].(elem)
+		if c.last != nil && c.tree.compare(c.last, p) >= 0 {
+			return fmt.Errorf("%w: %s element %d is out of order", ErrCorrupt, path, i)
+		}
+		c.last = p
+	}
+	if count != n.len() {
+		return fmt.Errorf("%w: %s counts %d, has %d", ErrCorrupt, path, n.len(), count)
+	}
+	return nil
+}
+
+// Dump writes the tree as indented text, one node per line
+func (tree *btree) Dump(w io.Writer) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root == nil {
+		_, err := fmt.Fprintln(w, "<empty>")
+		return err
+	}
+	return tree.root.dump(w, 0)
+}
+
+func (n *node) dump(w io.Writer, depth int) error {
+	if _, err := fmt.Fprintf(w, "%s[%s] count=%d\n", strings.Repeat("  ", depth), n.keys(" "), n.len()); err != nil {
+		return err
+	}
+	for i := 0; i <= len(n.elems); i++ {
+		if child := n.child(i); child != nil {
+			if err := child.dump(w, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DOT writes the tree in graphviz DOT format
+func (tree *btree) DOT(w io.Writer) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if _, err := fmt.Fprintln(w, "digraph btree {\n\tnode [shape=record];"); err != nil {
+		return err
+	}
+	if tree.root != nil {
+		id := 0
+		if err := tree.root.dot(w, &id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+func (n *node) dot(w io.Writer, id *int) error {
+	me := *id
+	fields := make([]string, 0, 2*len(n.elems)+1)
+	for i := 0; i <= len(n.elems); i++ {
+		fields = append(fields, fmt.Sprintf("<c%d>", i))
+		if i < len(n.elems) {
+			fields = append(fields, dotEscape(elemKey(n.elems[i])))
+		}
+	}
+	if _, err := fmt.Fprintf(w, "\tn%d [label=\"%s\"];\n", me, strings.Join(fields, "|")); err != nil {
+		return err
+	}
+	for i := 0; i <= len(n.elems); i++ {
+		child := n.child(i)
+		if child == nil {
+			continue
+		}
+		*id++
+		if _, err := fmt.Fprintf(w, "\tn%d:c%d -> n%d;\n", me, i, *id); err != nil {
+			return err
+		}
+		if err := child.dot(w, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *node) keys(sep string) string {
+	keys := make([]string, len(n.elems))
+	for i, p := range n.elems {
+		keys[i] = elemKey(p)
+	}
+	return strings.Join(keys, sep)
+}
+
+func elemKey(p Comparable) string {
+	if data, ok := p.(elem).data.(pair); ok {
+		return fmt.Sprintf("%q", data.Key)
+	}
+	return fmt.Sprintf("%v", p.(elem).data)
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "|", `\|`, "{", `\{`, "}", `\}`, "<", `\<`, ">", `\>`).Replace(s)
+}
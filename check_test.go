@@ -0,0 +1,75 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestTree_check(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(32)
+		for i := 0; i < 300; i++ {
+			k := fmt.Sprintf("%03d", r.Intn(100))
+			if r.Intn(3) == 0 {
+				tree.Del(SK(k))
+			} else {
+				tree.Put(SP(k, fmt.Sprint(i)))
+			}
+			if err := tree.Check(); err != nil {
+				var buf bytes.Buffer
+				tree.Dump(&buf)
+				t.Fatalf("seed %d step %d: %v\n%s", seed, i, err, buf.String())
+			}
+		}
+	}
+}
+
+func TestTree_checkCorrupt(t *testing.T) {
+	tree := createTree()
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+	tree.root.count++
+	if err := tree.Check(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("wrong count should be ErrCorrupt, got %v", err)
+	}
+	tree.root.count--
+	tree.root.first.p = nil
+	if err := tree.Check(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("wrong parent should be ErrCorrupt, got %v", err)
+	}
+	tree.root.first.p = tree.root
+	leaf := tree.root.leftmost()
+	leaf.elems[0], leaf.elems[1] = leaf.elems[1], leaf.elems[0]
+	if err := tree.Check(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("wrong order should be ErrCorrupt, got %v", err)
+	}
+}
+
+func TestTree_dump(t *testing.T) {
+	tree := createTree()
+	var buf bytes.Buffer
+	if err := tree.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.HasPrefix(lines[0], "[") || !strings.Contains(lines[0], fmt.Sprintf("count=%d", tree.Len())) {
+		t.Fatalf("dump should start with root, got %s", lines[0])
+	}
+	if len(lines) != countNodes(tree.root) {
+		t.Fatalf("dump should have a line per node, got\n%s", buf.String())
+	}
+	buf.Reset()
+	if err := tree.DOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph btree {") || strings.Count(dot, "->") != countNodes(tree.root)-1 {
+		t.Fatalf("bad dot output\n%s", dot)
+	}
+}
@@ -0,0 +1,279 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yang-zzhong/inf/tuple"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// Comparator orders keys of a btree, its name is persisted in the tree's meta
+// block so a tree can't be reopened with a different comparator
+type Comparator struct {
+	Name    string
+	Compare func(a, b []byte) int
+	// Check rejects keys Compare can't order, nil accepts any key
+	Check func(key []byte) error
+}
+
+var (
+	ErrComparator = errors.New("comparator mismatch")
+
+	BytesComparator           = Comparator{Name: "bytes", Compare: bytes.Compare}
+	UintComparator            = Comparator{Name: "uint-be", Compare: compareUint}
+	IntComparator             = Comparator{Name: "int-be", Compare: compareInt}
+	CaseInsensitiveComparator = Comparator{Name: "case-insensitive", Compare: compareFold}
+	CaseFoldComparator        = Comparator{Name: "case-fold", Compare: compareFoldBytes}
+
+	comparators = map[string]Comparator{}
+	cmpLock     sync.RWMutex
+)
+
+func init() {
+	for _, c := range []Comparator{BytesComparator, UintComparator, IntComparator, CaseInsensitiveComparator, CaseFoldComparator} {
+		RegisterComparator(c)
+	}
+}
+
+// RegisterComparator makes c resolvable by name when a tree is reopened
+func RegisterComparator(c Comparator) {
+	cmpLock.Lock()
+	defer cmpLock.Unlock()
+	comparators[c.Name] = c
+}
+
+// LookupComparator finds the comparator named name, names made by Reverse,
+// Tuple and Collate are resolved from their parts
+func LookupComparator(name string) (Comparator, error) {
+	cmpLock.RLock()
+	c, ok := comparators[name]
+	cmpLock.RUnlock()
+	if ok {
+		return c, nil
+	}
+	if inner := strings.TrimPrefix(name, "reverse("); inner != name && strings.HasSuffix(inner, ")") {
+		c, err := LookupComparator(inner[:len(inner)-1])
+		if err != nil {
+			return c, err
+		}
+		return Reverse(c), nil
+	}
+	if inner := strings.TrimPrefix(name, "tuple("); inner != name && strings.HasSuffix(inner, ")") {
+		names := splitNames(inner[:len(inner)-1])
+		cs := make([]Comparator, len(names))
+		for i, n := range names {
+			var err error
+			if cs[i], err = LookupComparator(n); err != nil {
+				return c, err
+			}
+		}
+		return Tuple(cs...), nil
+	}
+	if locale := strings.TrimPrefix(name, "collate("); locale != name && strings.HasSuffix(locale, ")") {
+		return Collate(locale[:len(locale)-1])
+	}
+	return c, fmt.Errorf("%w: unknown comparator %s", ErrComparator, name)
+}
+
+// splitNames splits names separated by commas which aren't in parentheses
+func splitNames(s string) []string {
+	names := []string{}
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				names = append(names, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(names, s[start:])
+}
+
+func Reverse(c Comparator) Comparator {
+	return Comparator{
+		Name: "reverse(" + c.Name + ")",
+		Compare: func(a, b []byte) int {
+			return c.Compare(b, a)
+		},
+		Check: c.Check,
+	}
+}
+
+// Tuple compares keys packed from byte strings by the tuple package part by
+// part, the ith part is compared by the ith comparator, extra parts are
+// compared as bytes. Keys which aren't such tuples are rejected by Check
+func Tuple(cs ...Comparator) Comparator {
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		names[i] = c.Name
+	}
+	return Comparator{
+		Name: "tuple(" + strings.Join(names, ",") + ")",
+		Compare: func(a, b []byte) int {
+			pa, erra := tupleParts(a)
+			pb, errb := tupleParts(b)
+			if erra != nil || errb != nil {
+				return bytes.Compare(a, b)
+			}
+			for i := 0; i < len(pa) && i < len(pb); i++ {
+				compare := bytes.Compare
+				if i < len(cs) {
+					compare = cs[i].Compare
+				}
+				if r := compare(pa[i], pb[i]); r != 0 {
+					return r
+				}
+			}
+			return len(pa) - len(pb)
+		},
+		Check: func(key []byte) error {
+			_, err := tupleParts(key)
+			return err
+		},
+	}
+}
+
+// Collate orders UTF-8 keys by the Unicode collation of locale, a BCP 47
+// tag such as "en" or "de-u-co-phonebk", which is kept in the name. Keys
+// which collate equal, e.g. in different normalization forms, are ordered
+// by bytes so they stay distinct keys
+func Collate(locale string) (Comparator, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return Comparator{}, fmt.Errorf("%w: bad locale %s: %s", ErrComparator, locale, err.Error())
+	}
+	// a collator keeps buffers so it can't be shared by goroutines
+	collators := sync.Pool{
+		New: func() interface{} {
+			return collate.New(tag)
+		},
+	}
+	return Comparator{
+		Name: "collate(" + tag.String() + ")",
+		Compare: func(a, b []byte) int {
+			c := collators.Get().(*collate.Collator)
+			r := c.Compare(a, b)
+			collators.Put(c)
+			if r != 0 {
+				return r
+			}
+			return bytes.Compare(a, b)
+		},
+	}, nil
+}
+
+// tupleKey packs parts into a key by the tuple package
+func tupleKey(parts ...[]byte) []byte {
+	t := make(tuple.Tuple, len(parts))
+	for i, p := range parts {
+		t[i] = append([]byte{}, p...)
+	}
+	// byte strings always pack
+	return tuple.MustPack(t...)
+}
+
+// tupleTree creates an in-memory tree for keys of the tree packed by
+// tupleKey with a few short parts, escaping doubles a key at most
+func (tree *btree) tupleTree() *btree {
+	total := 2*int(tree.total) + 64
+	if total > math.MaxUint16 {
+		total = math.MaxUint16
+	}
+	return NewTree(uint16(total))
+}
+
+// tupleParts unpacks a key of byte strings made by tupleKey
+func tupleParts(key []byte) ([][]byte, error) {
+	t, err := tuple.Unpack(key)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([][]byte, len(t))
+	for i, e := range t {
+		p, ok := e.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("%w: part %d isn't bytes", tuple.ErrMalformed, i)
+		}
+		parts[i] = p
+	}
+	return parts, nil
+}
+
+// compareUint compares big endian unsigned integers of any length
+func compareUint(a, b []byte) int {
+	a = bytes.TrimLeft(a, "\x00")
+	b = bytes.TrimLeft(b, "\x00")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return bytes.Compare(a, b)
+}
+
+// compareInt compares big endian two's complement integers of any length
+func compareInt(a, b []byte) int {
+	na := len(a) > 0 && a[0]&0x80 != 0
+	nb := len(b) > 0 && b[0]&0x80 != 0
+	if na != nb {
+		if na {
+			return -1
+		}
+		return 1
+	}
+	size := len(a)
+	if len(b) > size {
+		size = len(b)
+	}
+	return bytes.Compare(signExtend(a, size, na), signExtend(b, size, nb))
+}
+
+func signExtend(v []byte, size int, negative bool) []byte {
+	if len(v) == size {
+		return v
+	}
+	pad := byte(0)
+	if negative {
+		pad = 0xff
+	}
+	return append(bytes.Repeat([]byte{pad}, size-len(v)), v...)
+}
+
+// compareFold treats keys which differ only in case as the same key
+func compareFold(a, b []byte) int {
+	for len(a) > 0 && len(b) > 0 {
+		ra, sa := utf8.DecodeRune(a)
+		rb, sb := utf8.DecodeRune(b)
+		if la, lb := unicode.ToLower(ra), unicode.ToLower(rb); la != lb {
+			if la < lb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[sa:], b[sb:]
+	}
+	return len(a) - len(b)
+}
+
+// compareFoldBytes orders keys by their runes in lower case, keys which only
+// differ in case are ordered by bytes, so upper case first. It isn't a
+// collation, runes are ordered by code point, so "Éclair" is after "zebra",
+// use Collate for that
+func compareFoldBytes(a, b []byte) int {
+	if r := compareFold(a, b); r != 0 {
+		return r
+	}
+	return bytes.Compare(a, b)
+}
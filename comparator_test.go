@@ -0,0 +1,93 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestComparator(t *testing.T) {
+	cases := []struct {
+		c    Comparator
+		a, b []byte
+		r    int
+	}{
+		{UintComparator, []byte{0, 2}, []byte{1}, 1},
+		{UintComparator, []byte{1, 0}, []byte{0xff}, 1},
+		{IntComparator, []byte{0xff}, []byte{0, 1}, -1},
+		{IntComparator, []byte{0xfe}, []byte{0xff, 0xff}, -1},
+		{IntComparator, []byte{0x7f}, []byte{0x01, 0x00}, -1},
+		{CaseInsensitiveComparator, []byte("Hello"), []byte("hELLO"), 0},
+		{CaseFoldComparator, []byte("Banana"), []byte("apple"), 1},
+		{CaseFoldComparator, []byte("Éclair"), []byte("éclair"), -1},
+		{Reverse(BytesComparator), []byte("a"), []byte("b"), 1},
+		{Tuple(UintComparator, Reverse(BytesComparator)), tupleKey([]byte{2}, []byte("a")), tupleKey([]byte{10}, []byte("a")), -1},
+		{Tuple(UintComparator, Reverse(BytesComparator)), tupleKey([]byte{2}, []byte("a")), tupleKey([]byte{2}, []byte("b")), 1},
+	}
+	for _, c := range cases {
+		r := c.c.Compare(c.a, c.b)
+		if (r < 0 && c.r >= 0) || (r > 0 && c.r <= 0) || (r == 0 && c.r != 0) {
+			t.Fatalf("%s compare %v with %v should be %d", c.c.Name, c.a, c.b, c.r)
+		}
+	}
+	c, err := LookupComparator("tuple(uint-be,reverse(bytes))")
+	if err != nil || c.Name != "tuple(uint-be,reverse(bytes))" {
+		t.Fatalf("lookup composite comparator error")
+	}
+	en, err := Collate("en")
+	if err != nil {
+		t.Fatalf("collate error: %s", err.Error())
+	}
+	for _, w := range [][2]string{{"apple", "Banana"}, {"Éclair", "zebra"}, {"e\u0301", "\u00e9"}} {
+		if en.Compare([]byte(w[0]), []byte(w[1])) >= 0 {
+			t.Fatalf("%s should collate before %s", w[0], w[1])
+		}
+	}
+	if c, err := LookupComparator("collate(en)"); err != nil || c.Name != "collate(en)" || c.Compare([]byte("Éclair"), []byte("zebra")) >= 0 {
+		t.Fatalf("lookup collate comparator error")
+	}
+	if _, err := Collate("not a locale"); !errors.Is(err, ErrComparator) {
+		t.Fatalf("bad locale should be ErrComparator")
+	}
+	if _, err := LookupComparator("nope"); !errors.Is(err, ErrComparator) {
+		t.Fatalf("lookup unknown comparator should be ErrComparator")
+	}
+}
+
+func TestTree_SetComparator(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 0)
+			if err := tree.SetComparator(Reverse(UintComparator)); err != nil {
+				t.Fatalf("set comparator error: %s", err.Error())
+			}
+			for i := 1; i <= 20; i++ {
+				tree.Put(BP([]byte{byte(i)}, []byte(fmt.Sprint(i))))
+			}
+			if err := tree.SetComparator(BytesComparator); !errors.Is(err, ErrComparator) {
+				t.Fatalf("set comparator on non-empty tree should be ErrComparator")
+			}
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatalf("sync error: %s", err.Error())
+			}
+			if _, err := OpenTreeWith(s, meta, UintComparator); !errors.Is(err, ErrComparator) {
+				t.Fatalf("open with wrong comparator should be ErrComparator")
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatalf("open tree error: %s", err.Error())
+			}
+			prev := 21
+			walkKeys(loaded.root, func(key []byte) {
+				if int(key[0]) != prev-1 {
+					t.Fatalf("keys should be in reverse numeric order")
+				}
+				prev = int(key[0])
+			})
+			if val, err := loaded.Get(BK([]byte{0, 7})); err != nil || string(val.(pair).Val) != "7" {
+				t.Fatalf("get by numeric key error")
+			}
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package inf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec is the compression applied on a value before it's split into pages,
+// it's recorded in every page of the value so WriteTo can decompress it
+type Codec uint8
+
+const (
+	CodecNone  = Codec(0)
+	CodecFlate = Codec(1)
+	CodecZlib  = Codec(2)
+	CodecGzip  = Codec(3)
+)
+
+var (
+	ErrUnknownCodec = errors.New("unknown codec")
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecFlate:
+		return "flate"
+	case CodecZlib:
+		return "zlib"
+	case CodecGzip:
+		return "gzip"
+	}
+	return fmt.Sprintf("codec(%d)", uint8(c))
+}
+
+func (c Codec) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch c {
+	case CodecNone:
+		return data, nil
+	case CodecFlate:
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	case CodecZlib:
+		w = zlib.NewWriter(&buf)
+	case CodecGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Codec) reader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecNone:
+		return io.NopCloser(r), nil
+	case CodecFlate:
+		return flate.NewReader(r), nil
+	case CodecZlib:
+		return zlib.NewReader(r)
+	case CodecGzip:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+}
+
+func (c Codec) decompress(w io.Writer, blocks []Block) error {
+	readers := make([]io.Reader, len(blocks))
+	for i := range blocks {
+		readers[i] = bytes.NewReader(blocks[i].Data)
+	}
+	r, err := c.reader(io.MultiReader(readers...))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCorrupt, err.Error())
+	}
+	defer r.Close()
+	if _, err = io.Copy(w, r); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorrupt, err.Error())
+	}
+	return nil
+}
+
+// Storer is implemented by stores which store a value with a codec on their
+// own, other stores get the value compressed and put by Store
+type Storer interface {
+	Store(data []byte, c Codec) ([]Block, error)
+}
+
+// Store compresses data with c, then acquires and puts the pages of it. The
+// first block returned is where the value can be read back by WriteTo
+func (s *blockStore) Store(data []byte, c Codec) ([]Block, error) {
+	return storeBlocks(s, data, c)
+}
+
+// storeValue stores data with c into s, by s itself if it's a Storer
+func storeValue(s BlockStore, data []byte, c Codec) ([]Block, error) {
+	if st, ok := s.(Storer); ok {
+		return st.Store(data, c)
+	}
+	return storeBlocks(s, data, c)
+}
+
+func storeBlocks(s BlockStore, data []byte, c Codec) (blocks []Block, err error) {
+	if data, err = c.compress(data); err != nil {
+		return
+	}
+	// an empty value still takes a page to be read back from
+	size := len(data)
+	if size == 0 {
+		size = 1
+	}
+	if blocks, err = s.Acquire(size); err != nil {
+		return
+	}
+	max := int(s.DataSize())
+	for i := range blocks {
+		start, end := i*max, (i+1)*max
+		if start > len(data) {
+			start = len(data)
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		blocks[i].Codec = c
+		blocks[i].Data = data[start:end]
+	}
+	err = s.Put(blocks)
+	return
+}
@@ -0,0 +1,72 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_blockStore_Store(t *testing.T) {
+	data := bytes.Repeat([]byte("a highly compressible value "), 200)
+	for _, c := range []Codec{CodecNone, CodecFlate, CodecZlib, CodecGzip} {
+		cleanup(func() {
+			testBlockStore(t, func(s *blockStore) {
+				blocks, err := s.Store(data, c)
+				if err != nil {
+					t.Fatalf("store with %s error: %s", c, err.Error())
+				}
+				if c != CodecNone && len(blocks)*int(s.DataSize()) >= len(data) {
+					t.Fatalf("%s should compress the value", c)
+				}
+				var buf bytes.Buffer
+				read, err := s.WriteTo(&buf, blocks[0].Index())
+				if err != nil {
+					t.Fatalf("write to with %s error: %s", c, err.Error())
+				}
+				if read[0].Codec != c {
+					t.Fatalf("codec should be recorded as %s", c)
+				}
+				if !bytes.Equal(buf.Bytes(), data) {
+					t.Fatalf("decompress with %s error", c)
+				}
+			})
+		})
+	}
+}
+
+// plainStore hides Store of the block store
+type plainStore struct {
+	BlockStore
+}
+
+func Test_storeValue(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			blocks, err := s.Store(nil, CodecNone)
+			if err != nil || len(blocks) != 1 {
+				t.Fatalf("store empty value should take a block, got %d, %v", len(blocks), err)
+			}
+			var buf bytes.Buffer
+			if _, err := s.WriteTo(&buf, blocks[0].Index()); err != nil || buf.Len() != 0 {
+				t.Fatalf("read empty value error: %v", err)
+			}
+			data := bytes.Repeat([]byte("value "), 200)
+			if blocks, err = storeValue(plainStore{s}, data, CodecGzip); err != nil {
+				t.Fatalf("store into a plain store error: %s", err.Error())
+			}
+			buf.Reset()
+			if _, err := s.WriteTo(&buf, blocks[0].Index()); err != nil || !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("read value of a plain store error: %v", err)
+			}
+			mixed, _ := s.Acquire(int(s.DataSize()) * 2)
+			for i := range mixed {
+				mixed[i].Codec = Codec(i)
+				mixed[i].Data = []byte("x")
+			}
+			s.Put(mixed)
+			if _, err := s.WriteTo(&buf, mixed[0].Index()); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("value of mixed codecs should be ErrCorrupt, got %v", err)
+			}
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+)
+
+// PutIfAbsent puts data only if its key is not in the tree, it reports
+// whether data is put
+func (tree *btree) PutIfAbsent(data Storeable) (bool, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if _, err := tree.get(data); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	return true, tree.put(data)
+}
+
+// CompareAndSwap sets the value of key to new only if the current value is
+// old, it reports whether the value is swapped
+func (tree *btree) CompareAndSwap(key, old, new []byte) (bool, error) {
+	swapped := false
+	err := tree.Update(key, func(val []byte, ok bool) ([]byte, bool) {
+		if !ok {
+			return nil, false
+		}
+		if swapped = bytes.Equal(val, old); swapped {
+			return new, true
+		}
+		return val, true
+	})
+	return swapped, err
+}
+
+// DeleteIfEquals deletes key only if its value is val, it reports whether
+// key is deleted
+func (tree *btree) DeleteIfEquals(key, val []byte) (bool, error) {
+	deleted := false
+	err := tree.Update(key, func(old []byte, ok bool) ([]byte, bool) {
+		deleted = ok && bytes.Equal(old, val)
+		return old, ok && !deleted
+	})
+	return deleted, err
+}
+
+// Update replaces the value of key with the one returned by fn, or deletes
+// key if fn doesn't keep it. fn receives the current value and whether key
+// exists, it's called with the tree locked so it must not access the tree
+func (tree *btree) Update(key []byte, fn func(old []byte, ok bool) (new []byte, keep bool)) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	var old []byte
+	cur, err := tree.get(BK(key))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	ok := err == nil
+	if ok {
+		old = cur.(pair).Val
+	}
+	new, keep := fn(old, ok)
+	if !keep {
+		if ok {
+			return tree.del(BK(key))
+		}
+		return nil
+	}
+	if ok && bytes.Equal(new, old) {
+		return nil
+	}
+	return tree.put(BP(key, new))
+}
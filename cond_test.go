@@ -0,0 +1,72 @@
+package inf
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestTree_conditional(t *testing.T) {
+	tree := createTree()
+	if ok, err := tree.PutIfAbsent(SP("05", "x")); ok || err != nil {
+		t.Fatalf("put if absent on existing key should not put")
+	}
+	if ok, err := tree.PutIfAbsent(SP("11", "11")); !ok || err != nil {
+		t.Fatalf("put if absent on missing key should put")
+	}
+	if ok, _ := tree.CompareAndSwap([]byte("11"), []byte("xx"), []byte("12")); ok {
+		t.Fatalf("compare and swap with wrong old value should not swap")
+	}
+	if ok, _ := tree.CompareAndSwap([]byte("11"), []byte("11"), []byte("12")); !ok {
+		t.Fatalf("compare and swap with right old value should swap")
+	}
+	if val, _ := tree.Get(SK("11")); string(val.(pair).Val) != "12" {
+		t.Fatalf("value should be swapped")
+	}
+	if ok, _ := tree.CompareAndSwap([]byte("99"), nil, []byte("99")); ok {
+		t.Fatalf("compare and swap on missing key should not swap")
+	}
+	if ok, _ := tree.DeleteIfEquals([]byte("11"), []byte("11")); ok {
+		t.Fatalf("delete if equals with wrong value should not delete")
+	}
+	if ok, _ := tree.DeleteIfEquals([]byte("11"), []byte("12")); !ok {
+		t.Fatalf("delete if equals with right value should delete")
+	}
+	if _, err := tree.Get(SK("11")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("key should be deleted")
+	}
+}
+
+func TestTree_Update(t *testing.T) {
+	tree := NewTree(64)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tree.Update([]byte("counter"), func(old []byte, ok bool) ([]byte, bool) {
+					n := uint64(0)
+					if ok {
+						n = binary.BigEndian.Uint64(old)
+					}
+					new := make([]byte, 8)
+					binary.BigEndian.PutUint64(new, n+1)
+					return new, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := tree.Get(SK("counter"))
+	if err != nil || binary.BigEndian.Uint64(val.(pair).Val) != 800 {
+		t.Fatalf("updates should not race")
+	}
+	tree.Update([]byte("counter"), func(old []byte, ok bool) ([]byte, bool) {
+		return nil, false
+	})
+	if _, err := tree.Get(SK("counter")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("update without keep should delete the key")
+	}
+}
@@ -0,0 +1,218 @@
+package inf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// encrypted page data layout
+//
+// generation | nonce      | cipher text + tag
+// -----------|------------|---------------------
+// [1]byte    | [12]byte   | []byte + [16]byte
+//
+// key check in metadata, starts at byte 20 of metadata
+//
+// flags      | generation | nonce      | tag
+// -----------|------------|------------|----------
+// [1]byte    | [1]byte    | [12]byte   | [16]byte
+
+const (
+	flagEncrypted = byte(1)
+	keyCheckAt    = 20
+	nonceSize     = 12
+	tagSize       = 16
+	cryptOverhead = 1 + nonceSize + tagSize
+)
+
+var (
+	ErrBadKey = errors.New("bad encryption key")
+)
+
+type crypt struct {
+	keys       [][]byte
+	generation byte
+	aeads      map[byte]cipher.AEAD
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBadKey, err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetKey enables AES-GCM encryption of page data, it must be called before
+// Create or Open. key is the current key, previous keys are only needed when
+// reopening a store whose key rotation was interrupted, from the newest to
+// the oldest
+func (s *blockStore) SetKey(key []byte, previous ...[]byte) error {
+	for _, k := range append([][]byte{key}, previous...) {
+		if _, err := newAEAD(k); err != nil {
+			return err
+		}
+	}
+	s.crypt = &crypt{keys: append([][]byte{key}, previous...)}
+	return nil
+}
+
+func (s *blockStore) Encrypted() bool {
+	return s.crypt != nil
+}
+
+// Rotate switches page encryption to key. New writes use key immediately, the
+// existing pages are re-encrypted in the background, one page per lock
+// acquisition so other operations are not blocked. The returned channel
+// receives the result once all pages are re-encrypted. Pages record the key
+// generation in one byte, so a store can be rotated 255 times at most
+func (s *blockStore) Rotate(key []byte) <-chan error {
+	done := make(chan error, 1)
+	aead, err := newAEAD(key)
+	if err == nil {
+		err = s.ensureWritable(func() error {
+			if s.crypt == nil {
+				return fmt.Errorf("%w: store is not encrypted", ErrBadKey)
+			}
+			if s.crypt.generation == math.MaxUint8 {
+				return fmt.Errorf("%w: key generations exhausted", ErrBadKey)
+			}
+			s.crypt.generation++
+			s.crypt.aeads[s.crypt.generation] = aead
+			s.crypt.keys = append([][]byte{key}, s.crypt.keys...)
+			return s.syncMetaData()
+		})
+	}
+	if err != nil {
+		done <- err
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		done <- s.reencrypt()
+	}()
+	return done
+}
+
+func (s *blockStore) reencrypt() error {
+	var generation byte
+	for idx := 0; ; idx++ {
+		finished := false
+		err := s.ensure(func() error {
+			generation = s.crypt.generation
+			if idx > s.total {
+				finished = true
+				return nil
+			}
+			var page Block
+			bs := s.pagePool.Get().([]byte)
+			defer s.pagePool.Put(bs)
+			if err := s.readPage(bs, idx, &page); err != nil {
+				return err
+			}
+			if page.Type == TypeEmpty || bs[headerLen(page.Type)] == generation {
+				return nil
+			}
+			return s.putPage(idx, page.Type, page.Codec, page.Next, page.Data)
+		})
+		if err != nil {
+			return err
+		}
+		if finished {
+			break
+		}
+	}
+	// all pages are encrypted by the current key, drop the old ones
+	return s.ensure(func() error {
+		if s.crypt.generation != generation {
+			return nil
+		}
+		s.crypt.aeads = map[byte]cipher.AEAD{generation: s.crypt.aeads[generation]}
+		s.crypt.keys = s.crypt.keys[:1]
+		return nil
+	})
+}
+
+// init prepares the cipher of the current key when creating a store
+func (c *crypt) init() (err error) {
+	c.aeads = map[byte]cipher.AEAD{}
+	c.aeads[c.generation], err = newAEAD(c.keys[0])
+	return
+}
+
+// load verifies key against the key check material in metadata
+func (c *crypt) load(meta []byte) error {
+	if meta[0]&flagEncrypted == 0 {
+		return fmt.Errorf("%w: store is not encrypted", ErrBadKey)
+	}
+	c.generation = meta[1]
+	c.aeads = map[byte]cipher.AEAD{}
+	for i, key := range c.keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		c.aeads[c.generation-byte(i)] = aead
+	}
+	nonce := meta[2 : 2+nonceSize]
+	tag := meta[2+nonceSize : 2+nonceSize+tagSize]
+	if _, err := c.aeads[c.generation].Open(nil, nonce, tag, c.checkData()); err != nil {
+		return ErrBadKey
+	}
+	return nil
+}
+
+func (c *crypt) sync(meta []byte) error {
+	meta[0] = flagEncrypted
+	meta[1] = c.generation
+	nonce := meta[2 : 2+nonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	copy(meta[2+nonceSize:], c.aeads[c.generation].Seal(nil, nonce, nil, c.checkData()))
+	return nil
+}
+
+func (c *crypt) checkData() []byte {
+	return append(append([]byte{}, magicNumber[:]...), c.generation)
+}
+
+func (c *crypt) seal(idx int, head byte, next int, data []byte) ([]byte, error) {
+	sealed := make([]byte, 1+nonceSize, cryptOverhead+len(data))
+	sealed[0] = c.generation
+	if _, err := io.ReadFull(rand.Reader, sealed[1:]); err != nil {
+		return nil, err
+	}
+	return c.aeads[c.generation].Seal(sealed, sealed[1:], data, pageAD(idx, head, next)), nil
+}
+
+func (c *crypt) open(idx int, head byte, next int, sealed []byte) ([]byte, error) {
+	if len(sealed) < cryptOverhead {
+		return nil, &PageError{Index: idx, Err: ErrCorrupt}
+	}
+	aead, ok := c.aeads[sealed[0]]
+	if !ok {
+		return nil, &PageError{Index: idx, Err: ErrBadKey}
+	}
+	data, err := aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], pageAD(idx, head, next))
+	if err != nil {
+		return nil, &PageError{Index: idx, Err: fmt.Errorf("%w: %s", ErrCorrupt, err.Error())}
+	}
+	return data, nil
+}
+
+// pageAD binds the cipher text to the page it was written to
+func pageAD(idx int, head byte, next int) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint32(ad[0:4], uint32(idx))
+	ad[4] = head
+	binary.BigEndian.PutUint32(ad[5:9], uint32(next))
+	return ad
+}
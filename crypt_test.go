@@ -0,0 +1,114 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func writeChain(t *testing.T, s *blockStore, data []byte) int {
+	blocks, err := s.Acquire(len(data))
+	if err != nil {
+		t.Fatalf("acquire blocks error: %s", err.Error())
+	}
+	for i := range blocks {
+		start := i * int(s.DataSize())
+		end := start + int(s.DataSize())
+		if end > len(data) {
+			end = len(data)
+		}
+		blocks[i].Data = data[start:end]
+	}
+	if err := s.Put(blocks); err != nil {
+		t.Fatalf("put data error: %s", err.Error())
+	}
+	return blocks[0].Index()
+}
+
+func Test_blockStore_encrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	data := bytes.Repeat([]byte("customer secret "), 100)
+	cleanup(func() {
+		s := New(FileRWSC("./block.fsf"))
+		if err := s.SetKey(key); err != nil {
+			t.Fatalf("set key error: %s", err.Error())
+		}
+		if err := s.Create(V010000, 512); err != nil {
+			t.Fatalf("create error: %s", err.Error())
+		}
+		if s.DataSize() != 512-7-cryptOverhead {
+			t.Fatalf("data size should exclude nonce and tag")
+		}
+		idx := writeChain(t, s, data)
+		s.Close()
+		raw, _ := os.ReadFile("./block.fsf")
+		if bytes.Contains(raw, []byte("customer secret")) {
+			t.Fatalf("page data should be encrypted")
+		}
+		if err := New(FileRWSC("./block.fsf")).Open(); !errors.Is(err, ErrBadKey) {
+			t.Fatalf("open without key should be ErrBadKey")
+		}
+		wrong := New(FileRWSC("./block.fsf"))
+		wrong.SetKey(bytes.Repeat([]byte{2}, 32))
+		if err := wrong.Open(); !errors.Is(err, ErrBadKey) {
+			t.Fatalf("open with wrong key should be ErrBadKey")
+		}
+		s = New(FileRWSC("./block.fsf"))
+		s.SetKey(key)
+		if err := s.Open(); err != nil {
+			t.Fatalf("open error: %s", err.Error())
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf, idx); err != nil {
+			t.Fatalf("write to error: %s", err.Error())
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("decrypt error")
+		}
+		newKey := bytes.Repeat([]byte{3}, 32)
+		if err := <-s.Rotate(newKey); err != nil {
+			t.Fatalf("rotate error: %s", err.Error())
+		}
+		s.Close()
+		s = New(FileRWSC("./block.fsf"))
+		s.SetKey(newKey)
+		if err := s.Open(); err != nil {
+			t.Fatalf("open with rotated key error: %s", err.Error())
+		}
+		defer s.Close()
+		buf.Reset()
+		if _, err := s.WriteTo(&buf, idx); err != nil {
+			t.Fatalf("write to after rotate error: %s", err.Error())
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("decrypt after rotate error")
+		}
+	})
+}
+
+func Test_blockStore_RotateExhausted(t *testing.T) {
+	cleanup(func() {
+		s := New(FileRWSC("./block.fsf"))
+		if err := s.SetKey(bytes.Repeat([]byte{1}, 32)); err != nil {
+			t.Fatalf("set key error: %s", err.Error())
+		}
+		if err := s.Create(V010000, 512); err != nil {
+			t.Fatalf("create error: %s", err.Error())
+		}
+		defer s.Close()
+		idx := writeChain(t, s, []byte("customer secret"))
+		for i := 2; i <= 256; i++ {
+			if err := <-s.Rotate(bytes.Repeat([]byte{byte(i)}, 32)); err != nil {
+				t.Fatalf("rotate %d error: %s", i, err.Error())
+			}
+		}
+		if err := <-s.Rotate(bytes.Repeat([]byte{0}, 32)); !errors.Is(err, ErrBadKey) {
+			t.Fatalf("rotate should be rejected before the generation wraps")
+		}
+		var buf bytes.Buffer
+		if _, err := s.WriteTo(&buf, idx); err != nil || buf.String() != "customer secret" {
+			t.Fatalf("data should be readable after rejected rotate")
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrLagging = errors.New("watcher is lagging")
+	ErrClosed  = errors.New("watcher is closed")
+)
+
+// watchQueue is how many events a watcher can lag behind before it's
+// dropped
+const watchQueue = 4096
+
+// Event is a put or a delete of the tree, Val is nil for deletes
+type Event struct {
+	Seq uint64
+	Key []byte
+	Val []byte
+	Del bool
+}
+
+type changeFeed struct {
+	lock     sync.Mutex
+	log      []Event // the last writes, at most size
+	size     int
+	watchers map[*Watcher]struct{}
+}
+
+// Watcher receives the events of keys with a prefix in the order of their
+// sequence numbers. A consumer resumes from the sequence of the last event
+// it processed by watching again from it
+type Watcher struct {
+	feed   *changeFeed
+	prefix []byte
+	events chan Event
+	queue  []Event
+	wake   chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+func (tree *btree) changeFeed() *changeFeed {
+	if tree.feed == nil {
+		tree.feed = &changeFeed{watchers: map[*Watcher]struct{}{}}
+	}
+	return tree.feed
+}
+
+// EnableChangeLog keeps the last size writes, so watchers can resume from a
+// sequence up to size writes ago. The log is kept in memory only, sequence
+// numbers are synced with the tree, so a tree loaded by OpenTree goes on
+// from the synced sequence, but watchers can't resume from before it's loaded,
+// Watch returns ErrPruned for them. Pending merge operands are compacted,
+// so they are logged, merges are combined at once from then on
+func (tree *btree) EnableChangeLog(size int) error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	f := tree.changeFeed()
+	f.lock.Lock()
+	f.size = size
+	if len(f.log) > size {
+		f.log = f.log[len(f.log)-size:]
+	}
+	f.lock.Unlock()
+	_, err := tree.compact()
+	return err
+}
+
+// Watch watches the writes of keys with prefix after sequence from, nil
+// prefix watches all keys. Writes from the change log are sent first, it
+// returns ErrPruned if some writes after from are not in the change log.
+// Pending merge operands are compacted, so the watcher sees them, merges are
+// combined at once from then on
+func (tree *btree) Watch(prefix []byte, from uint64) (*Watcher, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	w, err := tree.watch(prefix, from)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tree.compact(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (tree *btree) watch(prefix []byte, from uint64) (*Watcher, error) {
+	f := tree.changeFeed()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	oldest := tree.seq + 1
+	if len(f.log) > 0 {
+		oldest = f.log[0].Seq
+	}
+	if from+1 < oldest {
+		return nil, fmt.Errorf("%w: writes after %d are before the change log", ErrPruned, from)
+	}
+	w := &Watcher{
+		feed:   f,
+		prefix: prefix,
+		events: make(chan Event),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for _, e := range f.log {
+		if e.Seq > from && bytes.HasPrefix(e.Key, prefix) {
+			w.queue = append(w.queue, e)
+		}
+	}
+	f.watchers[w] = struct{}{}
+	w.signal()
+	go w.run()
+	return w, nil
+}
+
+// publish takes the next sequence number for the write of data and sends it
+// to the watchers, so events are in the order of sequence numbers when
+// writers are concurrent
+func (tree *btree) publish(data Storeable, del bool) uint64 {
+	f := tree.feed
+	if f == nil {
+		return atomic.AddUint64(&tree.seq, 1)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	seq := atomic.AddUint64(&tree.seq, 1)
+	p, ok := data.(pair)
+	if !ok {
+		return seq
+	}
+	e := Event{Seq: seq, Key: p.Key, Del: del}
+	if !del {
+		e.Val = p.Val
+	}
+	if f.size > 0 {
+		if f.log = append(f.log, e); len(f.log) > f.size {
+			f.log = f.log[len(f.log)-f.size:]
+		}
+	}
+	for w := range f.watchers {
+		if !bytes.HasPrefix(e.Key, w.prefix) {
+			continue
+		}
+		if len(w.queue) >= watchQueue {
+			w.stop(ErrLagging)
+			continue
+		}
+		w.queue = append(w.queue, e)
+		w.signal()
+	}
+	return seq
+}
+
+func (w *Watcher) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop removes w from the feed, it's called with the feed locked
+func (w *Watcher) stop(err error) {
+	if w.err != nil {
+		return
+	}
+	w.err = err
+	delete(w.feed.watchers, w)
+	close(w.done)
+}
+
+// run sends the queued events until w is stopped, the events are taken one
+// by one so the queue tells how far w is behind
+func (w *Watcher) run() {
+	defer close(w.events)
+	for {
+		w.feed.lock.Lock()
+		if len(w.queue) == 0 {
+			w.feed.lock.Unlock()
+			select {
+			case <-w.done:
+				return
+			case <-w.wake:
+				continue
+			}
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.feed.lock.Unlock()
+		select {
+		case w.events <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Events returns the channel of events, it's closed once the watcher is
+// stopped, Err tells why
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns ErrClosed if the watcher is closed, ErrLagging if it's dropped
+// because it's too far behind the writes, the consumer should watch again
+// from the last event it processed
+func (w *Watcher) Err() error {
+	w.feed.lock.Lock()
+	defer w.feed.lock.Unlock()
+	return w.err
+}
+
+func (w *Watcher) Close() {
+	w.feed.lock.Lock()
+	defer w.feed.lock.Unlock()
+	w.stop(ErrClosed)
+}
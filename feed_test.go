@@ -0,0 +1,139 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, w *Watcher) Event {
+	select {
+	case e, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("watcher is stopped: %v", w.Err())
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event")
+	}
+	return Event{}
+}
+
+func TestTree_Watch(t *testing.T) {
+	tree := NewTree(32)
+	w, err := tree.Watch([]byte("user:"), tree.Seq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.Put(SP("user:1", "a"))
+	tree.Put(SP("item:1", "b"))
+	tree.Del(SK("user:1"))
+	e := nextEvent(t, w)
+	if e.Seq != 1 || string(e.Key) != "user:1" || string(e.Val) != "a" || e.Del {
+		t.Fatalf("first event should be put user:1, got %+v", e)
+	}
+	e = nextEvent(t, w)
+	if e.Seq != 3 || string(e.Key) != "user:1" || !e.Del {
+		t.Fatalf("second event should be del user:1, got %+v", e)
+	}
+	w.Close()
+	if _, ok := <-w.Events(); ok || !errors.Is(w.Err(), ErrClosed) {
+		t.Fatalf("closed watcher should be ErrClosed")
+	}
+	if _, err := tree.Watch(nil, 0); !errors.Is(err, ErrPruned) {
+		t.Fatalf("watch from before the change log should be ErrPruned")
+	}
+}
+
+func TestTree_WatchResume(t *testing.T) {
+	tree := NewTree(32)
+	tree.EnableChangeLog(10)
+	for i := 0; i < 20; i++ {
+		tree.Put(SP(fmt.Sprintf("%02d", i), "v"))
+	}
+	if _, err := tree.Watch(nil, 5); !errors.Is(err, ErrPruned) {
+		t.Fatalf("watch from pruned sequence should be ErrPruned")
+	}
+	w, err := tree.Watch(nil, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	tree.Put(SP("20", "v"))
+	for seq := uint64(16); seq <= 21; seq++ {
+		if e := nextEvent(t, w); e.Seq != seq {
+			t.Fatalf("event should be %d, got %d", seq, e.Seq)
+		}
+	}
+}
+
+func TestTree_WatchConcurrent(t *testing.T) {
+	tree := NewTree(64)
+	w, _ := tree.Watch(nil, 0)
+	defer w.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				tree.Put(SP(fmt.Sprintf("%d-%03d", g, i), "v"))
+			}
+		}(g)
+	}
+	for seq := uint64(1); seq <= 1000; seq++ {
+		if e := nextEvent(t, w); e.Seq != seq {
+			t.Fatalf("events should be in order, %d got %d", seq, e.Seq)
+		}
+	}
+	wg.Wait()
+}
+
+func TestTree_WatchLagging(t *testing.T) {
+	tree := NewTree(64)
+	w, _ := tree.Watch(nil, 0)
+	for i := 0; i < watchQueue+10; i++ {
+		tree.Put(SP(fmt.Sprint(i), "v"))
+	}
+	for range w.Events() {
+	}
+	if !errors.Is(w.Err(), ErrLagging) {
+		t.Fatalf("lagging watcher should be ErrLagging, got %v", w.Err())
+	}
+}
+
+func TestTree_WatchOpen(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 0)
+			for i := 0; i < 10; i++ {
+				tree.Put(SP(fmt.Sprintf("%02d", i), "v"))
+			}
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Seq() != 10 {
+				t.Fatalf("seq should be synced, got %d", loaded.Seq())
+			}
+			if _, err := loaded.Watch(nil, 5); !errors.Is(err, ErrPruned) {
+				t.Fatalf("watch from before the tree is loaded should be ErrPruned")
+			}
+			w, err := loaded.Watch(nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			loaded.Put(SP("10", "v"))
+			if e := nextEvent(t, w); e.Seq != 11 {
+				t.Fatalf("event should go on from the synced seq, got %d", e.Seq)
+			}
+		})
+	})
+}
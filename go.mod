@@ -1,5 +1,8 @@
 module github.com/yang-zzhong/inf
 
-go 1.17
+go 1.18
 
-require github.com/golang/mock v1.6.0
+require (
+	github.com/golang/mock v1.6.0
+	golang.org/x/text v0.14.0
+)
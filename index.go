@@ -0,0 +1,222 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Extractor returns the index keys of a pair, nil if it isn't indexed
+type Extractor func(key, val []byte) [][]byte
+
+// Index is a secondary index of a tree, it's updated with every put and
+// delete of the tree, in the same batch if they are written by a batch. The
+// entries of a put are checked before the pair is written, so updating them
+// after doesn't fail. Entries are kept in memory only, they aren't synced, an
+// index of a tree loaded by OpenTree is added again by AddIndex
+type Index struct {
+	name    string
+	tree    *btree
+	extract Extractor
+	entries *btree // index key and primary key packed by tupleKey
+	keys    *btree // primary key to its index keys packed by tupleKey
+}
+
+// AddIndex creates the index name of the tree with extract, the pairs already
+// in the tree are indexed, pending merge operands are compacted first and
+// merges are combined at once from then on
+func (tree *btree) AddIndex(name string, extract Extractor) (*Index, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if _, ok := tree.indexes[name]; ok {
+		return nil, fmt.Errorf("%w: index %s", ErrExists, name)
+	}
+	if _, err := tree.compact(); err != nil {
+		return nil, err
+	}
+	idx := &Index{name: name, tree: tree, extract: extract, entries: tree.tupleTree(), keys: tree.tupleTree()}
+	idx.entries.cmp = Tuple(BytesComparator, tree.Comparator())
+	idx.keys.cmp = tree.cmp
+	it := &nodeIterator{tree: tree}
+	it.descend(tree.root)
+	for it.Next() {
+		if err := idx.update(it.Data(), false); err != nil {
+			return nil, err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if tree.indexes == nil {
+		tree.indexes = map[string]*Index{}
+	}
+	tree.indexes[name] = idx
+	return idx, nil
+}
+
+// Index returns the index name, nil if there isn't
+func (tree *btree) Index(name string) *Index {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.indexes[name]
+}
+
+func (tree *btree) DropIndex(name string) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	delete(tree.indexes, name)
+}
+
+func (idx *Index) Name() string {
+	return idx.name
+}
+
+// checkIndexes rejects data whose entries don't fit in the trees of an index
+// of tree, before data is written
+func (tree *btree) checkIndexes(data Storeable) error {
+	p, ok := data.(pair)
+	if !ok {
+		return nil
+	}
+	for _, idx := range tree.indexes {
+		iks := idx.extract(p.Key, p.Val)
+		if len(iks) == 0 {
+			continue
+		}
+		for _, ik := range iks {
+			if err := idx.entries.fits(BK(tupleKey(ik, p.Key))); err != nil {
+				return fmt.Errorf("index %s: %w", idx.name, err)
+			}
+		}
+		if err := idx.keys.fits(pair{Key: p.Key, Val: tupleKey(iks...)}); err != nil {
+			return fmt.Errorf("index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
+
+// update removes the entries of the pair data from the index, then adds its
+// new entries if it's put
+func (idx *Index) update(data Storeable, del bool) error {
+	p, ok := data.(pair)
+	if !ok {
+		return nil
+	}
+	if old, err := idx.keys.Get(BK(p.Key)); err == nil {
+		iks, err := tupleParts(old.(pair).Val)
+		if err != nil {
+			return fmt.Errorf("%w: index keys of %x: %s", ErrCorrupt, p.Key, err.Error())
+		}
+		for _, ik := range iks {
+			if err := idx.entries.Del(BK(tupleKey(ik, p.Key))); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if err := idx.keys.Del(BK(p.Key)); err != nil {
+			return err
+		}
+	}
+	if del {
+		return nil
+	}
+	iks := idx.extract(p.Key, p.Val)
+	if len(iks) == 0 {
+		return nil
+	}
+	for _, ik := range iks {
+		if err := idx.entries.Put(BK(tupleKey(ik, p.Key))); err != nil {
+			return err
+		}
+	}
+	return idx.keys.Put(pair{Key: p.Key, Val: tupleKey(iks...)})
+}
+
+// Lookup returns the pairs whose index keys have key
+func (idx *Index) Lookup(key []byte) ([]Storeable, error) {
+	items := []Storeable{}
+	// key with a 0 after is the first index key after key
+	it := idx.Scan(key, append(key[:len(key):len(key)], 0))
+	for it.Next() {
+		items = append(items, it.Data())
+	}
+	return items, it.Err()
+}
+
+// Scan iterates over the pairs whose index keys are in [start, end) in the
+// order of the index keys, nil end means unbounded. A pair is returned once
+// for each of its index keys in the range
+func (idx *Index) Scan(start, end []byte) Iterator {
+	return &indexIterator{idx: idx, start: start, end: end}
+}
+
+type indexIterator struct {
+	idx        *Index
+	start, end []byte
+	last       Storeable // the last entry
+	data       Storeable
+	err        error
+}
+
+func (it *indexIterator) Next() bool {
+	entries := it.idx.entries
+	for it.err == nil {
+		var i int
+		if it.last == nil {
+			i = entries.Rank(BK(tupleKey(it.start)))
+		} else {
+			// seek again, entries may be changed since the last one
+			i = entries.Rank(it.last)
+			if found, err := entries.Select(i); err == nil && entries.compare(elem{data: found}, elem{data: it.last}) == 0 {
+				i++
+			}
+		}
+		found, err := entries.Select(i)
+		if err != nil {
+			return false
+		}
+		it.last = found
+		entry, err := tupleParts(found.(pair).Key)
+		if err != nil || len(entry) != 2 {
+			it.err = fmt.Errorf("%w: index entry %x", ErrCorrupt, found.(pair).Key)
+			return false
+		}
+		if it.end != nil && bytes.Compare(entry[0], it.end) >= 0 {
+			return false
+		}
+		it.data, err = it.idx.tree.Get(BK(entry[1]))
+		if errors.Is(err, ErrNotFound) {
+			// deleted since the entry is read
+			continue
+		}
+		if it.err = err; err != nil {
+			return false
+		}
+		if it.idx.has(it.data, entry[0]) {
+			return true
+		}
+		// changed since the entry is read, it's found by its new entries
+	}
+	return false
+}
+
+// has tells whether ik is an index key of the pair data
+func (idx *Index) has(data Storeable, ik []byte) bool {
+	p, ok := data.(pair)
+	if !ok {
+		return false
+	}
+	for _, k := range idx.extract(p.Key, p.Val) {
+		if bytes.Equal(k, ik) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *indexIterator) Data() Storeable {
+	return it.data
+}
+
+func (it *indexIterator) Err() error {
+	return it.err
+}
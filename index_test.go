@@ -0,0 +1,139 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// byCity indexes values like "name,city" by city
+func byCity(key, val []byte) [][]byte {
+	if i := bytes.IndexByte(val, ','); i >= 0 {
+		return [][]byte{val[i+1:]}
+	}
+	return nil
+}
+
+func lookupKeys(t *testing.T, idx *Index, key string) string {
+	items, err := idx.Lookup([]byte(key))
+	if err != nil {
+		t.Fatalf("lookup %s error: %s", key, err.Error())
+	}
+	keys := []string{}
+	for _, item := range items {
+		keys = append(keys, string(item.(pair).Key))
+	}
+	sort.Strings(keys)
+	return fmt.Sprint(keys)
+}
+
+func TestIndex(t *testing.T) {
+	tree := NewTree(64)
+	tree.Put(SP("1", "ann,paris"))
+	tree.Put(SP("2", "bob,rome"))
+	idx, err := tree.AddIndex("city", byCity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.AddIndex("city", byCity); !errors.Is(err, ErrExists) {
+		t.Fatalf("add index twice should be ErrExists")
+	}
+	tree.Put(SP("3", "cat,paris"))
+	tree.Put(SP("4", "dan"))
+	if got := lookupKeys(t, idx, "paris"); got != "[1 3]" {
+		t.Fatalf("paris should be [1 3], got %s", got)
+	}
+	tree.Put(SP("1", "ann,rome"))
+	tree.Del(SK("2"))
+	if got := lookupKeys(t, idx, "paris"); got != "[3]" {
+		t.Fatalf("paris should be [3], got %s", got)
+	}
+	if got := lookupKeys(t, idx, "rome"); got != "[1]" {
+		t.Fatalf("rome should be [1], got %s", got)
+	}
+	if got := lookupKeys(t, idx, "par"); got != "[]" {
+		t.Fatalf("par should be [], got %s", got)
+	}
+	it := idx.Scan([]byte("p"), nil)
+	vals := []string{}
+	for it.Next() {
+		vals = append(vals, string(it.Data().(pair).Val))
+	}
+	if fmt.Sprint(vals) != "[cat,paris ann,rome]" {
+		t.Fatalf("scan should be in the order of cities, got %v", vals)
+	}
+	if tree.Index("city") != idx {
+		t.Fatalf("index should be found by name")
+	}
+}
+
+func TestIndex_batch(t *testing.T) {
+	tree := NewTree(64)
+	idx, _ := tree.AddIndex("city", byCity)
+	tree.Put(SP("1", "ann,paris"))
+	b := NewWriteBatch().Put(SP("2", "bob,paris")).Del(SK("1")).Put(SP("3", "cat,oslo"))
+	if _, err := tree.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := lookupKeys(t, idx, "paris"); got != "[2]" {
+		t.Fatalf("paris should be [2], got %s", got)
+	}
+	if got := lookupKeys(t, idx, "oslo"); got != "[3]" {
+		t.Fatalf("oslo should be [3], got %s", got)
+	}
+	tree.DropIndex("city")
+	tree.Put(SP("4", "dan,oslo"))
+	if got := lookupKeys(t, idx, "oslo"); got != "[3]" {
+		t.Fatalf("dropped index should not be updated, got %s", got)
+	}
+}
+
+func TestIndex_staleEntry(t *testing.T) {
+	tree := NewTree(64)
+	idx, _ := tree.AddIndex("city", byCity)
+	tree.Put(SP("1", "ann,paris"))
+	tree.Put(SP("2", "bob,rome"))
+	// an entry read before the pair moved to paris
+	idx.entries.Put(BK(tupleKey([]byte("rome"), []byte("1"))))
+	if got := lookupKeys(t, idx, "rome"); got != "[2]" {
+		t.Fatalf("stale entry should be skipped, got %s", got)
+	}
+	if got := lookupKeys(t, idx, "paris"); got != "[1]" {
+		t.Fatalf("paris should be [1], got %s", got)
+	}
+}
+
+func TestIndex_tooLarge(t *testing.T) {
+	tree := NewTree(64)
+	idx, err := tree.AddIndex("city", func(key, val []byte) [][]byte {
+		// the city ten times
+		if iks := byCity(key, val); len(iks) > 0 {
+			return [][]byte{bytes.Repeat(iks[0], 10)}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put(SP("1", "ann,paris")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put(SP("1", "ann,amsterdam-west")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("put with a too large index entry should be ErrValueTooLarge, got %v", err)
+	}
+	if err := tree.Put(SP("2", "bob,amsterdam-west")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("put with a too large index entry should be ErrValueTooLarge, got %v", err)
+	}
+	if data, err := tree.Get(SK("1")); err != nil || string(data.(pair).Val) != "ann,paris" {
+		t.Fatalf("failed put should keep the value")
+	}
+	if _, err := tree.Get(SK("2")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("failed put should not write the pair")
+	}
+	if got := lookupKeys(t, idx, strings.Repeat("paris", 10)); got != "[1]" {
+		t.Fatalf("failed put should keep the index, got %s", got)
+	}
+}
@@ -0,0 +1,42 @@
+package inf
+
+// Iterator iterates over Storeables, Next must be called before the first
+// Data, and Err reports the error which stops the iteration
+type Iterator interface {
+	Next() bool
+	Data() Storeable
+	Err() error
+}
+
+// IteratorCloser is an Iterator which holds resources until it's iterated to
+// the end or closed, Close can be called more than once
+type IteratorCloser interface {
+	Iterator
+	Close() error
+}
+
+type sliceIterator struct {
+	items []Storeable
+	pos   int
+}
+
+// SliceIterator iterates over items in order
+func SliceIterator(items []Storeable) Iterator {
+	return &sliceIterator{items: items, pos: -1}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.pos+1 >= len(it.items) {
+		return false
+	}
+	it.pos++
+	return true
+}
+
+func (it *sliceIterator) Data() Storeable {
+	return it.items[it.pos]
+}
+
+func (it *sliceIterator) Err() error {
+	return nil
+}
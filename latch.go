@@ -0,0 +1,120 @@
+package inf
+
+import "sync/atomic"
+
+// Latching
+//
+// The tree lock is held exclusively by operations which change the structure
+// of the tree: splitting, merging, copying nodes for snapshots, replacing the
+// root. Puts and deletes which fit in their node hold it shared, so they
+// never see the structure change, and latch only the nodes they read or
+// change. They descend optimistically: read latches are coupled from the
+// root, the latch of a node is released once the latch of its child is taken,
+// then the node to change is latched exclusively. If the change doesn't fit,
+// the latch is released and the operation is retried under the tree lock.
+// Subtree counts of the ancestors are changed atomically
+
+// descend read latches the nodes from n towards data, it returns the node
+// which holds data or the leaf data belongs to, still read latched, and the
+// ancestors of it
+func (n *node) descend(data Storeable) (*node, []*node) {
+	var path []*node
+	n.latch.RLock()
+	for {
+		pos, exactly := n.shouldBe(elem{data: data})
+		child := n.child(pos)
+		if exactly || child == nil {
+			return n, path
+		}
+		child.latch.RLock()
+		n.latch.RUnlock()
+		path = append(path, n)
+		n = child
+	}
+}
+
+// putLatched puts stored in place if it fits in its node, it reports whether
+// the put is done
+func (tree *btree) putLatched(stored, data Storeable) (bool, error) {
+	if tree.root == nil || len(tree.snapshots) > 0 {
+		return false, nil
+	}
+	if err := tree.checkIndexes(data); err != nil {
+		return true, err
+	}
+	n, path := tree.root.descend(stored)
+	n.latch.RUnlock()
+	n.latch.Lock()
+	defer n.latch.Unlock()
+	p := elem{data: stored}
+	// the node may be changed by others between the latches
+	pos, exactly := n.shouldBe(p)
+	if exactly {
+		old := n.elems[pos].(elem)
+		p.after = old.after
+		n.elems[pos] = p
+		// keys which compare equal may differ in bytes, so the prefixes the
+		// neighbours share are counted again
+		if n.shouldUse() > int(tree.total) {
+			n.elems[pos] = old
+			return false, nil
+		}
+		if err := tree.release(old.data); err != nil {
+			return true, err
+		}
+		return true, tree.record(data, false)
+	}
+	if !n.leaf() {
+		return false, nil
+	}
+	n.elems.insertAt(pos, p)
+	if n.shouldUse() > int(tree.total) {
+		n.elems = append(n.elems[:pos], n.elems[pos+1:]...)
+		return false, nil
+	}
+	n.add(path, 1)
+	return true, tree.record(data, false)
+}
+
+// delLatched deletes data in place if it's in a leaf with other elements, it
+// reports whether the delete is done
+func (tree *btree) delLatched(data Storeable) (bool, error) {
+	if tree.root == nil {
+		return true, ErrNotFound
+	}
+	if len(tree.snapshots) > 0 {
+		return false, nil
+	}
+	n, path := tree.root.descend(data)
+	n.latch.RUnlock()
+	n.latch.Lock()
+	defer n.latch.Unlock()
+	pos, exactly := n.shouldBe(elem{data: data})
+	if !exactly {
+		return true, ErrNotFound
+	}
+	if !n.leaf() || len(n.elems) == 1 {
+		return false, nil
+	}
+	old := n.elems[pos]
+	n.elems = append(n.elems[:pos], n.elems[pos+1:]...)
+	// with prefix compression the next element may share less with the one
+	// before it
+	if n.shouldUse() > int(tree.total) {
+		n.elems.insertAt(pos, old)
+		return false, nil
+	}
+	n.add(path, -1)
+	if err := tree.release(old.(elem).data); err != nil {
+		return true, err
+	}
+	return true, tree.record(data, true)
+}
+
+// add adds delta to the counts of n and its ancestors in path
+func (n *node) add(path []*node, delta int64) {
+	atomic.AddInt64(&n.count, delta)
+	for _, a := range path {
+		atomic.AddInt64(&a.count, delta)
+	}
+}
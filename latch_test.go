@@ -0,0 +1,78 @@
+package inf
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestTree_concurrent(t *testing.T) {
+	tree := NewTree(64)
+	tree.EnableVersions()
+	const workers, keys = 8, 200
+	var wg sync.WaitGroup
+	live := make([]map[string]bool, workers)
+	for w := 0; w < workers; w++ {
+		live[w] = map[string]bool{}
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 2000; i++ {
+				k := fmt.Sprintf("%d-%03d", w, r.Intn(keys))
+				switch r.Intn(4) {
+				case 0:
+					if err := tree.Del(SK(k)); (err == nil) != live[w][k] {
+						t.Errorf("del %s error: %v", k, err)
+					}
+					delete(live[w], k)
+				case 1:
+					tree.Get(SK(k))
+					tree.Rank(SK(k))
+					tree.Select(r.Intn(keys))
+				default:
+					if err := tree.Put(SP(k, fmt.Sprint(i))); err != nil {
+						t.Errorf("put %s error: %v", k, err)
+					}
+					live[w][k] = true
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for w := range live {
+		total += len(live[w])
+		for k := range live[w] {
+			if _, err := tree.Get(SK(k)); err != nil {
+				t.Fatalf("lost %s", k)
+			}
+		}
+	}
+	if tree.Len() != total {
+		t.Fatalf("len should be %d, got %d", total, tree.Len())
+	}
+}
+
+func TestTree_latchedPut(t *testing.T) {
+	tree := createTree()
+	root := tree.root
+	// 01 fits in the leaf of 00, the tree isn't changed but the leaf
+	tree.Del(SK("01"))
+	tree.lock.RLock()
+	done, err := tree.putLatched(SP("01", "01"), SP("01", "01"))
+	tree.lock.RUnlock()
+	if !done || err != nil {
+		t.Fatalf("put into leaf with room should be done latched")
+	}
+	if tree.root != root {
+		t.Fatalf("latched put should not change the structure")
+	}
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,265 @@
+package inf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+var (
+	ErrNoMergeOperator = errors.New("no merge operator")
+)
+
+// MergeOperator combines the operands merged into a key with its value,
+// existing is nil if the key has no value, operands are from the oldest
+type MergeOperator struct {
+	Name  string
+	Merge func(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+var (
+	// AddOperator adds 8 bytes big endian unsigned integers
+	AddOperator = MergeOperator{Name: "add-uint64", Merge: mergeAdd}
+	// AppendOperator appends operands to the value
+	AppendOperator = MergeOperator{Name: "append", Merge: mergeAppend}
+)
+
+func mergeAdd(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var sum uint64
+	for _, v := range append([][]byte{existing}, operands...) {
+		if v == nil {
+			continue
+		}
+		if len(v) != 8 {
+			return nil, fmt.Errorf("%w: %d bytes is not uint64", ErrInvalidBlock, len(v))
+		}
+		sum += binary.BigEndian.Uint64(v)
+	}
+	val := make([]byte, 8)
+	binary.BigEndian.PutUint64(val, sum)
+	return val, nil
+}
+
+func mergeAppend(key, existing []byte, operands [][]byte) ([]byte, error) {
+	val := append([]byte{}, existing...)
+	for _, v := range operands {
+		val = append(val, v...)
+	}
+	return val, nil
+}
+
+// SetMergeOperator sets how Merge combines operands
+func (tree *btree) SetMergeOperator(op MergeOperator) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.merger = op
+	if tree.deltas == nil {
+		tree.deltas = NewTree(tree.total)
+	}
+}
+
+// Merge keeps the value of data as an operand of its key without reading the
+// current value, operands are combined by the merge operator on Get and by
+// Compact. Only the node of the key is latched. A later put or delete of the
+// key drops its operands. Reads which count or visit keys compact the
+// operands first. A tree with versions, indexes or watchers combines the
+// operand with the value at once, so the merge is recorded like a put
+func (tree *btree) Merge(data Storeable) error {
+	p, ok := data.(pair)
+	if !ok {
+		return fmt.Errorf("%w: only pair can be merged", ErrInvalidBlock)
+	}
+	tree.lock.RLock()
+	if tree.deltas == nil {
+		tree.lock.RUnlock()
+		return ErrNoMergeOperator
+	}
+	if tree.folds() {
+		tree.lock.RUnlock()
+		tree.lock.Lock()
+		defer tree.lock.Unlock()
+		return tree.fold(p)
+	}
+	if tree.root == nil {
+		tree.lock.RUnlock()
+		tree.lock.Lock()
+		defer tree.lock.Unlock()
+		return tree.addDelta(p)
+	}
+	defer tree.lock.RUnlock()
+	n, _ := tree.root.descend(data)
+	n.latch.RUnlock()
+	n.latch.Lock()
+	defer n.latch.Unlock()
+	return tree.addDelta(p)
+}
+
+// folds tells whether merges are combined when they are made, writes must
+// be recorded for versions, indexes and watchers then
+func (tree *btree) folds() bool {
+	return tree.history != nil || len(tree.indexes) > 0 || tree.feed != nil
+}
+
+// fold combines the operand p with the value of its key and puts the result
+func (tree *btree) fold(p pair) error {
+	var existing []byte
+	old, err := tree.get(BK(p.Key))
+	if err == nil {
+		existing = old.(pair).Val
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	val, err := tree.merger.Merge(p.Key, existing, [][]byte{p.Val})
+	if err != nil {
+		return err
+	}
+	return tree.put(pair{Key: p.Key, Val: val})
+}
+
+// compactDue compacts the operands if there are, reads which count or visit
+// keys call it first so they agree with Get
+func (tree *btree) compactDue() error {
+	tree.lock.RLock()
+	due := tree.deltas != nil && tree.deltas.root != nil
+	tree.lock.RUnlock()
+	if !due {
+		return nil
+	}
+	_, err := tree.Compact()
+	return err
+}
+
+func (tree *btree) addDelta(p pair) error {
+	seq := atomic.AddUint64(&tree.deltaSeq, 1)
+	return tree.deltas.Put(keyVersion{key: p.Key, val: append([]byte{}, p.Val...), seq: seq, cmp: tree.Comparator().Compare})
+}
+
+// operands returns the operands of key from the oldest. The operands are
+// sought one after another from the newest, each seek takes the position
+// after the last operand, so merges between seeks don't move it
+func (tree *btree) operands(key []byte) [][]byte {
+	var operands [][]byte
+	v := keyVersion{key: key, seq: math.MaxUint64, cmp: tree.Comparator().Compare}
+	for {
+		found, err := tree.deltas.seek(v)
+		if err != nil || v.cmp(found.(keyVersion).key, key) != 0 {
+			break
+		}
+		fv := found.(keyVersion)
+		operands = append(operands, fv.val)
+		if fv.seq == 0 {
+			break
+		}
+		v.seq = fv.seq - 1
+	}
+	for i, j := 0, len(operands)-1; i < j; i, j = i+1, j-1 {
+		operands[i], operands[j] = operands[j], operands[i]
+	}
+	return operands
+}
+
+func (tree *btree) clearDeltas(data Storeable) error {
+	p, ok := data.(pair)
+	if tree.deltas == nil || !ok {
+		return nil
+	}
+	v := keyVersion{key: p.Key, seq: math.MaxUint64, cmp: tree.Comparator().Compare}
+	for {
+		found, err := tree.deltas.seek(v)
+		if err != nil || v.cmp(found.(keyVersion).key, p.Key) != 0 {
+			return nil
+		}
+		if err := tree.deltas.Del(found); err != nil {
+			return err
+		}
+	}
+}
+
+// getMerged reads the value and the operands of data under the latch of its
+// node, and combines them
+func (tree *btree) getMerged(data Storeable) (Storeable, error) {
+	p, ok := data.(pair)
+	if !ok {
+		return nil, fmt.Errorf("%w: only pair can be merged", ErrInvalidBlock)
+	}
+	var base Storeable
+	var operands [][]byte
+	if tree.root != nil {
+		n, _ := tree.root.descend(data)
+		if pos, exactly := n.shouldBe(elem{data: data}); exactly {
+			base = n.elems[pos].(elem).data
+		}
+		operands = tree.operands(p.Key)
+		n.latch.RUnlock()
+	} else {
+		operands = tree.operands(p.Key)
+	}
+	if base != nil && tree.expired(base) {
+		return nil, errExpired
+	}
+	if len(operands) == 0 {
+		if base == nil {
+			return nil, ErrNotFound
+		}
+		return tree.load(base)
+	}
+	var existing []byte
+	if base != nil {
+		loaded, err := tree.load(base)
+		if err != nil {
+			return nil, err
+		}
+		p, existing = loaded.(pair), loaded.(pair).Val
+	}
+	val, err := tree.merger.Merge(p.Key, existing, operands)
+	if err != nil {
+		return nil, err
+	}
+	return pair{Key: p.Key, Val: val}, nil
+}
+
+// Compact combines the operands of every key into its value, it returns the
+// number of keys compacted
+func (tree *btree) Compact() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.compact()
+}
+
+func (tree *btree) compact() (int, error) {
+	if tree.deltas == nil || tree.deltas.root == nil {
+		return 0, nil
+	}
+	var keys [][]byte
+	it := &nodeIterator{tree: tree.deltas}
+	it.descend(tree.deltas.root)
+	for it.Next() {
+		v := it.Data().(keyVersion)
+		if l := len(keys); l == 0 || v.cmp(keys[l-1], v.key) != 0 {
+			keys = append(keys, v.key)
+		}
+	}
+	for _, key := range keys {
+		merged, err := tree.get(BK(key))
+		if errors.Is(err, ErrNotFound) {
+			err = tree.clearDeltas(BK(key))
+		} else if err == nil {
+			err = tree.put(merged)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// delMerged deletes a key which has operands only
+func (tree *btree) delMerged(data Storeable) error {
+	p, ok := data.(pair)
+	if tree.deltas == nil || !ok || len(tree.operands(p.Key)) == 0 {
+		return ErrNotFound
+	}
+	return tree.record(data, true)
+}
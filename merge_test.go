@@ -0,0 +1,198 @@
+package inf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func u64(v uint64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, v)
+	return bs
+}
+
+func TestTree_Merge(t *testing.T) {
+	tree := NewTree(64)
+	if err := tree.Merge(SP("a", "1")); !errors.Is(err, ErrNoMergeOperator) {
+		t.Fatalf("merge without operator should be ErrNoMergeOperator")
+	}
+	tree.SetMergeOperator(AppendOperator)
+	for i := 0; i < 100; i++ {
+		tree.Put(SP(fmt.Sprintf("%03d", i), "v"))
+	}
+	tree.Merge(SP("050", "1"))
+	tree.Merge(SP("050", "2"))
+	tree.Merge(SP("new", "x"))
+	if val, err := tree.Get(SK("050")); err != nil || string(val.(pair).Val) != "v12" {
+		t.Fatalf("merged value error: %v", val)
+	}
+	if val, err := tree.Get(SK("new")); err != nil || string(val.(pair).Val) != "x" {
+		t.Fatalf("merged value without base error: %v", val)
+	}
+	if _, err := tree.Get(SK("none")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get key without value and operands should be ErrNotFound")
+	}
+	tree.Put(SP("050", "p"))
+	if val, _ := tree.Get(SK("050")); string(val.(pair).Val) != "p" {
+		t.Fatalf("put should drop operands")
+	}
+	tree.Merge(SP("050", "3"))
+	tree.Del(SK("new"))
+	if _, err := tree.Get(SK("new")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete should drop operands")
+	}
+	if n, err := tree.Compact(); err != nil || n != 1 {
+		t.Fatalf("compact error: %d, %v", n, err)
+	}
+	if tree.deltas.Len() != 0 {
+		t.Fatalf("compact should drop operands")
+	}
+	if val, _ := tree.Get(SK("050")); string(val.(pair).Val) != "p3" || tree.Len() != 100 {
+		t.Fatalf("compacted value error: %v", val)
+	}
+	if err := tree.Check(); err != nil {
+		t.Fatalf("check error: %s", err)
+	}
+}
+
+func TestTree_MergeVisible(t *testing.T) {
+	tree := NewTree(64)
+	tree.SetMergeOperator(AppendOperator)
+	tree.Put(SP("a", "1"))
+	tree.Merge(SP("a", "2"))
+	tree.Merge(SP("b", "x"))
+	if tree.Len() != 2 {
+		t.Fatalf("merged only key should be counted, len %d", tree.Len())
+	}
+	snap := tree.Snapshot()
+	tree.Merge(SP("c", "y"))
+	it := snap.Iterator()
+	var got []string
+	for it.Next() {
+		p := it.Data().(pair)
+		got = append(got, string(p.Key)+"="+string(p.Val))
+	}
+	snap.Close()
+	if fmt.Sprint(got) != "[a=12 b=x]" {
+		t.Fatalf("snapshot should see merged values, got %v", got)
+	}
+	if max, err := tree.Max(); err != nil || string(max.(pair).Key) != "c" || tree.Rank(SK("d")) != 3 {
+		t.Fatalf("merged only key should be ordered")
+	}
+	w, err := tree.Watch(nil, tree.Seq())
+	if err != nil {
+		t.Fatalf("watch error: %s", err.Error())
+	}
+	defer w.Close()
+	seq := tree.Seq()
+	tree.Merge(SP("a", "3"))
+	if e := <-w.Events(); e.Seq != seq+1 || string(e.Key) != "a" || string(e.Val) != "123" {
+		t.Fatalf("merge should be published with its value, got %+v", e)
+	}
+	if tree.deltas.Len() != 0 {
+		t.Fatalf("merges of a watched tree should be combined at once")
+	}
+}
+
+func TestTree_MergeConcurrent(t *testing.T) {
+	tree := NewTree(64)
+	tree.SetMergeOperator(AddOperator)
+	for i := 0; i < 50; i++ {
+		tree.Put(BP([]byte(fmt.Sprintf("%03d", i)), u64(0)))
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				tree.Merge(BP([]byte(fmt.Sprintf("%03d", i%50)), u64(1)))
+				tree.Get(SK(fmt.Sprintf("%03d", i%50)))
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 50; i++ {
+		val, err := tree.Get(SK(fmt.Sprintf("%03d", i)))
+		if err != nil || binary.BigEndian.Uint64(val.(pair).Val) != 32 {
+			t.Fatalf("counter %d error", i)
+		}
+	}
+	tree.Compact()
+	if val, _ := tree.Get(SK("007")); binary.BigEndian.Uint64(val.(pair).Val) != 32 {
+		t.Fatalf("compacted counter error")
+	}
+}
+
+func TestTree_MergeGetConcurrent(t *testing.T) {
+	tree := NewTree(64)
+	tree.SetMergeOperator(AddOperator)
+	keys := 40
+	started := make([]uint64, keys) // merges begun per key
+	merged := make([]uint64, keys)  // merges returned per key
+	// keys have values, so merges only latch their nodes
+	for k := 0; k < keys; k++ {
+		tree.Put(BP([]byte(fmt.Sprintf("%03d", k)), u64(0)))
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := (i*7 + w) % keys
+				atomic.AddUint64(&started[k], 1)
+				tree.Merge(BP([]byte(fmt.Sprintf("%03d", k)), u64(1)))
+				atomic.AddUint64(&merged[k], 1)
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k := (i*3 + r) % keys
+				low := atomic.LoadUint64(&merged[k])
+				val, err := tree.Get(SK(fmt.Sprintf("%03d", k)))
+				high := atomic.LoadUint64(&started[k])
+				if err != nil {
+					t.Errorf("get %d error: %s", k, err.Error())
+					return
+				}
+				// all merges returned before get and none begun after
+				if v := binary.BigEndian.Uint64(val.(pair).Val); v < low || v > high {
+					t.Errorf("counter %d is %d, should be in [%d, %d]", k, v, low, high)
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func TestTree_MergeSync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 32)
+			tree.SetMergeOperator(AppendOperator)
+			tree.Put(SP("a", "1"))
+			tree.Merge(SP("a", "2"))
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatalf("sync error: %s", err)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatalf("open tree error: %s", err)
+			}
+			if val, err := loaded.Get(SK("a")); err != nil || string(val.(pair).Val) != "12" {
+				t.Fatalf("sync should compact operands")
+			}
+		})
+	})
+}
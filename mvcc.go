@@ -0,0 +1,247 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	ErrNoVersions = errors.New("versions are not enabled")
+	ErrPruned     = errors.New("version is collected")
+)
+
+// keyVersion is a put or a delete of a key at a sequence number, versions of a
+// key are ordered from the newest to the oldest
+type keyVersion struct {
+	key, val []byte
+	seq      uint64
+	del      bool
+	cmp      func(a, b []byte) int
+}
+
+func (v keyVersion) Compare(t Comparable) int {
+	o := t.(keyVersion)
+	if r := v.cmp(v.key, o.key); r != 0 {
+		return r
+	}
+	switch {
+	case v.seq > o.seq:
+		return -1
+	case v.seq < o.seq:
+		return 1
+	}
+	return 0
+}
+
+func (v keyVersion) Bytes(max int) [][]byte {
+	return [][]byte{v.key}
+}
+
+// Size counts the key and the sequence only. The history is never synced, so
+// the value is never in a node page, nodes of the history hold values beyond
+// total in memory
+func (v keyVersion) Size(max int) int {
+	return len(v.key) + 8
+}
+
+// EnableVersions keeps every put and delete of the tree as a version at its
+// sequence number, so the tree can be read as of an earlier sequence by GetAt
+// and IteratorAt. It's only allowed on an empty tree. Versions are kept in
+// memory until they are collected by GC, they aren't written by Sync, so a
+// tree loaded by OpenTree has no versions
+func (tree *btree) EnableVersions() error {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.root != nil || (tree.deltas != nil && tree.deltas.root != nil) {
+		return ErrNotEmpty
+	}
+	if tree.history == nil {
+		tree.history = NewTree(tree.total)
+	}
+	return nil
+}
+
+// Seq returns the sequence number of the last write, every put and delete
+// increases it by 1
+func (tree *btree) Seq() uint64 {
+	return atomic.LoadUint64(&tree.seq)
+}
+
+// change is a write of a batch waiting for the batch to commit
+type change struct {
+	data Storeable
+	del  bool
+}
+
+// record clears the TTL and merge operands of data and updates the indexes,
+// then the write takes the next sequence number, it's published to watchers
+// and kept as a version. Writes of a batch take their sequence numbers when
+// the batch commits, so a reverted batch leaves no events and versions
+func (tree *btree) record(data Storeable, del bool) error {
+	if err := tree.unexpire(data); err != nil {
+		return err
+	}
+	if err := tree.clearDeltas(data); err != nil {
+		return err
+	}
+	for _, idx := range tree.indexes {
+		if err := idx.update(data, del); err != nil {
+			return err
+		}
+	}
+	if tree.batching {
+		tree.changes = append(tree.changes, change{data: data, del: del})
+		return nil
+	}
+	return tree.version(data, del)
+}
+
+// version takes the next sequence number for the write of data, publishes
+// it and keeps it in the history
+func (tree *btree) version(data Storeable, del bool) error {
+	seq := tree.publish(data, del)
+	if tree.history == nil {
+		return nil
+	}
+	p, ok := data.(pair)
+	if !ok {
+		return fmt.Errorf("%w: only pair can be versioned", ErrInvalidBlock)
+	}
+	v := keyVersion{key: p.Key, seq: seq, del: del, cmp: tree.Comparator().Compare}
+	if !del {
+		v.val = p.Val
+	}
+	return tree.history.Put(v)
+}
+
+// GetAt returns data as it was after the write of sequence seq
+func (tree *btree) GetAt(data Storeable, seq uint64) (Storeable, error) {
+	if err := tree.settle(); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.history == nil {
+		return nil, ErrNoVersions
+	}
+	if seq < tree.pruned {
+		return nil, fmt.Errorf("%w: %d is before %d", ErrPruned, seq, tree.pruned)
+	}
+	p, ok := data.(pair)
+	if !ok {
+		return nil, fmt.Errorf("%w: only pair can be versioned", ErrInvalidBlock)
+	}
+	v := keyVersion{key: p.Key, seq: seq, cmp: tree.Comparator().Compare}
+	// the newest version at or before seq
+	found, err := tree.history.seek(v)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if fv := found.(keyVersion); v.cmp(fv.key, p.Key) == 0 && fv.seq <= seq && !fv.del {
+		return pair{Key: fv.key, Val: fv.val}, nil
+	}
+	return nil, ErrNotFound
+}
+
+// IteratorAt iterates over the pairs of the tree as it was after the write of
+// sequence seq in order. It reads a snapshot of the versions, so it doesn't
+// block writers, the snapshot is held until the iterator is iterated to the
+// end or closed
+func (tree *btree) IteratorAt(seq uint64) (IteratorCloser, error) {
+	if err := tree.settle(); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.history == nil {
+		return nil, ErrNoVersions
+	}
+	if seq < tree.pruned {
+		return nil, fmt.Errorf("%w: %d is before %d", ErrPruned, seq, tree.pruned)
+	}
+	snap := tree.history.Snapshot()
+	return &versionIterator{snap: snap, it: snap.Iterator(), seq: seq}, nil
+}
+
+type versionIterator struct {
+	snap *Snapshot
+	it   Iterator
+	seq  uint64
+	last *keyVersion // the last key decided
+	data Storeable
+}
+
+func (it *versionIterator) Next() bool {
+	for it.it.Next() {
+		v := it.it.Data().(keyVersion)
+		if v.seq > it.seq || (it.last != nil && v.cmp(v.key, it.last.key) == 0) {
+			continue
+		}
+		it.last = &v
+		if !v.del {
+			it.data = pair{Key: v.key, Val: v.val}
+			return true
+		}
+	}
+	it.Close()
+	return false
+}
+
+// Close releases the snapshot of the versions
+func (it *versionIterator) Close() error {
+	return it.snap.Close()
+}
+
+func (it *versionIterator) Data() Storeable {
+	return it.data
+}
+
+func (it *versionIterator) Err() error {
+	return it.it.Err()
+}
+
+// GC collects the versions which can't be read by any open snapshot, only the
+// newest version of a key at the oldest snapshot and the versions after are
+// kept, without snapshots only the live versions are kept. It returns the
+// number of versions collected
+func (tree *btree) GC() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.history == nil {
+		return 0, ErrNoVersions
+	}
+	horizon := tree.seq
+	for s := range tree.snapshots {
+		if s.seq < horizon {
+			horizon = s.seq
+		}
+	}
+	var victims []keyVersion
+	var last *keyVersion
+	kept := false // a version of last at or before horizon is kept
+	it := &nodeIterator{tree: tree.history}
+	it.descend(tree.history.root)
+	for it.Next() {
+		v := it.Data().(keyVersion)
+		if last == nil || v.cmp(v.key, last.key) != 0 {
+			last, kept = &v, false
+		}
+		if v.seq > horizon {
+			continue
+		}
+		if kept || v.del {
+			victims = append(victims, v)
+		}
+		kept = true
+	}
+	for _, v := range victims {
+		if err := tree.history.Del(v); err != nil {
+			return 0, err
+		}
+	}
+	if horizon > tree.pruned {
+		tree.pruned = horizon
+	}
+	return len(victims), nil
+}
@@ -0,0 +1,172 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestTree_GetAt(t *testing.T) {
+	tree := NewTree(32)
+	if _, err := tree.GetAt(SK("a"), 0); !errors.Is(err, ErrNoVersions) {
+		t.Fatalf("get at without versions should be ErrNoVersions")
+	}
+	if err := tree.EnableVersions(); err != nil {
+		t.Fatal(err)
+	}
+	tree.Put(SP("a", "1"))
+	tree.Put(SP("b", "1"))
+	tree.Put(SP("a", "2"))
+	tree.Del(SK("b"))
+	if tree.Seq() != 4 {
+		t.Fatalf("seq should be 4, got %d", tree.Seq())
+	}
+	cases := []struct {
+		key string
+		seq uint64
+		val string
+	}{
+		{"a", 0, ""}, {"a", 1, "1"}, {"a", 2, "1"}, {"a", 3, "2"}, {"a", 9, "2"},
+		{"b", 1, ""}, {"b", 2, "1"}, {"b", 3, "1"}, {"b", 4, ""}, {"c", 4, ""},
+	}
+	for _, c := range cases {
+		data, err := tree.GetAt(SK(c.key), c.seq)
+		if c.val == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("%s at %d should be ErrNotFound", c.key, c.seq)
+			}
+			continue
+		}
+		if err != nil || string(data.(pair).Val) != c.val {
+			t.Fatalf("%s at %d should be %s", c.key, c.seq, c.val)
+		}
+	}
+}
+
+func TestTree_GetAtConcurrent(t *testing.T) {
+	tree := NewTree(64)
+	tree.EnableVersions()
+	for i := 0; i < 50; i++ {
+		tree.Put(SP(fmt.Sprintf("%02d", i), "0"))
+	}
+	seq := tree.Seq()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				tree.Put(SP(fmt.Sprintf("%02d", (i*7+w)%50), fmt.Sprint(i+1)))
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				k := fmt.Sprintf("%02d", (i*3+r)%50)
+				if data, err := tree.GetAt(SK(k), seq); err != nil || string(data.(pair).Val) != "0" {
+					t.Errorf("%s at %d should be 0, got %v %v", k, seq, data, err)
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
+
+func keysAt(t *testing.T, tree *btree, seq uint64) []string {
+	it, err := tree.IteratorAt(seq)
+	if err != nil {
+		t.Fatalf("iterator at %d error: %s", seq, err.Error())
+	}
+	keys := []string{}
+	for it.Next() {
+		p := it.Data().(pair)
+		keys = append(keys, string(p.Key)+"="+string(p.Val))
+	}
+	return keys
+}
+
+func TestTree_IteratorAt(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tree := NewTree(32)
+	tree.EnableVersions()
+	states := [][]string{{}}
+	live := map[string]string{}
+	for i := 0; i < 300; i++ {
+		k := fmt.Sprintf("%02d", r.Intn(40))
+		if r.Intn(3) == 0 {
+			if tree.Del(SK(k)) != nil {
+				continue
+			}
+			delete(live, k)
+		} else {
+			v := fmt.Sprint(i)
+			tree.Put(SP(k, v))
+			live[k] = v
+		}
+		state := []string{}
+		for k, v := range live {
+			state = append(state, k+"="+v)
+		}
+		sort.Strings(state)
+		states = append(states, state)
+	}
+	if tree.Seq() != uint64(len(states)-1) {
+		t.Fatalf("seq should be %d, got %d", len(states)-1, tree.Seq())
+	}
+	for seq, state := range states {
+		if got := keysAt(t, tree, uint64(seq)); fmt.Sprint(got) != fmt.Sprint(state) {
+			t.Fatalf("keys at %d\n%v\nshould be\n%v", seq, got, state)
+		}
+	}
+	it, err := tree.IteratorAt(tree.Seq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Next()
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.history.snapshots) != 0 {
+		t.Fatalf("closed iterator should release its snapshot")
+	}
+}
+
+func TestTree_GC(t *testing.T) {
+	tree := NewTree(32)
+	tree.EnableVersions()
+	for i := 0; i < 10; i++ {
+		tree.Put(SP("a", fmt.Sprint(i)))
+	}
+	tree.Put(SP("b", "1"))
+	tree.Del(SK("b"))
+	snap := tree.Snapshot()
+	tree.Put(SP("a", "new"))
+	n, err := tree.GC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a 0-8 and both versions of b
+	if n != 11 {
+		t.Fatalf("gc should collect 11 versions, got %d", n)
+	}
+	if data, err := tree.GetAt(SK("a"), snap.Seq()); err != nil || string(data.(pair).Val) != "9" {
+		t.Fatalf("a at snapshot should be kept")
+	}
+	if _, err := tree.GetAt(SK("a"), snap.Seq()-1); !errors.Is(err, ErrPruned) {
+		t.Fatalf("a before snapshot should be ErrPruned")
+	}
+	snap.Close()
+	if n, _ := tree.GC(); n != 1 {
+		t.Fatalf("gc should collect a 9, got %d", n)
+	}
+	if got := keysAt(t, tree, tree.Seq()); fmt.Sprint(got) != "[a=new]" {
+		t.Fatalf("live keys should be kept, got %v", got)
+	}
+}
@@ -0,0 +1,425 @@
+package inf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// node page layout, all integers are big endian
+//
+// type    | flags   | count   | first   | elements
+// --------|---------|---------|---------|-------------
+// [1]byte | [1]byte | [2]byte | [4]byte | []element
+//
+// element
+//
+// shared  | key len | kind    | val len | key     | val     | after
+// --------|---------|---------|---------|---------|---------|---------
+// [2]byte | [2]byte | [1]byte | [4]byte | []byte  | []byte  | [4]byte
+//
+// type is nodeLeaf or nodeInterior, first and after are the block indexes of
+// the children, 0 means no child. When flags has flagPrefix, shared is the
+// length of the prefix the key shares with the previous key in the node and
+// only the rest of the key is stored. kind is kindInline if val is the value
+// itself or kindRef if val is the 4 bytes index of the value's block chain
+//
+// tree meta page layout
+//
+// magic   | total   | overflow | root    | cmp len | cmp name | ttl     | seq
+// --------|---------|----------|---------|---------|----------|---------|---------
+// [4]byte | [2]byte | [4]byte  | [4]byte | [1]byte | []byte   | [4]byte | [8]byte
+//
+// magic is treeMagic for a btree and bplusMagic for a bplusTree, whose nodes
+// are node pages as well. ttl is the meta block of the tree of deadlines, 0
+// if no key has a TTL. seq is the sequence number of the last write synced.
+// Fields missing in metas written before them are read as 0
+
+const (
+	nodeLeaf     = byte(1)
+	nodeInterior = byte(2)
+	flagPrefix   = byte(1)
+	kindInline   = byte(0)
+	kindRef      = byte(1)
+	nodeHeadSize = 8
+	elemHeadSize = 9
+	treeMetaSize = 15
+)
+
+var treeMagic = [4]byte{'b', 't', 'r', 'e'}
+
+type pageElem struct {
+	data  pair
+	after int
+}
+
+type nodePage struct {
+	leaf   bool
+	prefix bool
+	first  int
+	elems  []pageElem
+}
+
+func encodeNode(page *nodePage) []byte {
+	size := nodeHeadSize
+	for _, e := range page.elems {
+		size += elemHeadSize + len(e.data.Key) + len(e.data.Val) + refSize + 4
+	}
+	bs := make([]byte, nodeHeadSize, size)
+	bs[0] = nodeInterior
+	if page.leaf {
+		bs[0] = nodeLeaf
+	}
+	if page.prefix {
+		bs[1] = flagPrefix
+	}
+	binary.BigEndian.PutUint16(bs[2:4], uint16(len(page.elems)))
+	binary.BigEndian.PutUint32(bs[4:8], uint32(page.first))
+	var prev []byte
+	for _, e := range page.elems {
+		key := e.data.Key
+		shared := 0
+		if page.prefix {
+			shared = sharedPrefix(prev, key)
+			prev = key
+		}
+		kind, val := kindInline, e.data.Val
+		if e.data.ref != 0 {
+			kind, val = kindRef, make([]byte, refSize)
+			binary.BigEndian.PutUint32(val, uint32(e.data.ref))
+		}
+		head := make([]byte, elemHeadSize)
+		binary.BigEndian.PutUint16(head[0:2], uint16(shared))
+		binary.BigEndian.PutUint16(head[2:4], uint16(len(key)-shared))
+		head[4] = kind
+		binary.BigEndian.PutUint32(head[5:9], uint32(len(val)))
+		bs = append(bs, head...)
+		bs = append(bs, key[shared:]...)
+		bs = append(bs, val...)
+		after := make([]byte, 4)
+		binary.BigEndian.PutUint32(after, uint32(e.after))
+		bs = append(bs, after...)
+	}
+	return bs
+}
+
+func decodeNode(bs []byte) (*nodePage, error) {
+	if len(bs) < nodeHeadSize || (bs[0] != nodeLeaf && bs[0] != nodeInterior) {
+		return nil, fmt.Errorf("%w: malformed node page", ErrCorrupt)
+	}
+	page := &nodePage{
+		leaf:   bs[0] == nodeLeaf,
+		prefix: bs[1]&flagPrefix != 0,
+		first:  int(binary.BigEndian.Uint32(bs[4:8])),
+		elems:  make([]pageElem, binary.BigEndian.Uint16(bs[2:4])),
+	}
+	pos := nodeHeadSize
+	var prev []byte
+	for i := range page.elems {
+		if pos+elemHeadSize > len(bs) {
+			return nil, fmt.Errorf("%w: node page element %d truncated", ErrCorrupt, i)
+		}
+		shared := int(binary.BigEndian.Uint16(bs[pos : pos+2]))
+		keyLen := int(binary.BigEndian.Uint16(bs[pos+2 : pos+4]))
+		kind := bs[pos+4]
+		valLen := int(binary.BigEndian.Uint32(bs[pos+5 : pos+9]))
+		pos += elemHeadSize
+		if shared > len(prev) || pos+keyLen+valLen+4 > len(bs) {
+			return nil, fmt.Errorf("%w: node page element %d truncated", ErrCorrupt, i)
+		}
+		key := make([]byte, shared+keyLen)
+		copy(key, prev[:shared])
+		copy(key[shared:], bs[pos:pos+keyLen])
+		pos += keyLen
+		e := pageElem{data: pair{Key: key}}
+		switch kind {
+		case kindInline:
+			e.data.Val = append([]byte{}, bs[pos:pos+valLen]...)
+		case kindRef:
+			if valLen != refSize {
+				return nil, fmt.Errorf("%w: node page element %d bad ref", ErrCorrupt, i)
+			}
+			e.data.ref = int(binary.BigEndian.Uint32(bs[pos : pos+refSize]))
+		default:
+			return nil, fmt.Errorf("%w: node page element %d unknown kind", ErrCorrupt, i)
+		}
+		pos += valLen
+		e.after = int(binary.BigEndian.Uint32(bs[pos : pos+4]))
+		pos += 4
+		page.elems[i] = e
+		prev = key
+	}
+	return page, nil
+}
+
+// sharedKey returns the length of the prefix shared by the keys of pairs a
+// and b
+func sharedKey(a, b Storeable) int {
+	pa, aok := a.(pair)
+	pb, bok := b.(pair)
+	if !aok || !bok {
+		return 0
+	}
+	return sharedPrefix(pa.Key, pb.Key)
+}
+
+func sharedPrefix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Sync writes all nodes of the tree into new block chains of its store, then
+// erases the blocks written by the last Sync, so the tree in store is always
+// complete. It returns the index of the tree's meta block which OpenTree
+// accepts
+func (tree *btree) Sync() (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	return tree.sync()
+}
+
+func (tree *btree) sync() (int, error) {
+	if tree.store == nil {
+		return 0, fmt.Errorf("%w: tree has no store", ErrNotPrepared)
+	}
+	// merge operands are in memory only
+	if _, err := tree.compact(); err != nil {
+		return 0, err
+	}
+	old := tree.blocks
+	tree.blocks = nil
+	if tree.root != nil {
+		if err := tree.root.sync(tree.store); err != nil {
+			return 0, tree.unsync(old, err)
+		}
+	}
+	return tree.syncRoot(old)
+}
+
+// syncRoot writes the meta of the tree whose node pages are written, then
+// erases old, the pages written by the last Sync
+func (tree *btree) syncRoot(old []int) (int, error) {
+	m := treeMeta{total: tree.total, overflow: tree.overflow, cmp: tree.Comparator().Name, seq: tree.Seq()}
+	if tree.root != nil {
+		m.root = tree.root.block
+	}
+	if tree.ttl != nil {
+		var err error
+		if m.ttl, err = tree.ttl.deadlines.sync(); err != nil {
+			return 0, tree.unsync(old, err)
+		}
+	}
+	data, err := encodeMeta(treeMagic, m)
+	if err != nil {
+		return 0, tree.unsync(old, err)
+	}
+	if tree.meta, err = syncMeta(tree.store, tree.meta, data); err != nil {
+		return 0, tree.unsync(old, err)
+	}
+	for _, idx := range old {
+		if err := eraseChain(tree.store, idx); err != nil {
+			return 0, err
+		}
+	}
+	return tree.meta, nil
+}
+
+// unsync erases the pages written by a failed sync and restores old, the
+// pages of the tree in store, so the next sync erases them
+func (tree *btree) unsync(old []int, cause error) error {
+	written := tree.blocks
+	tree.blocks = old
+	return erasePages(tree.store, written, cause)
+}
+
+// erasePages erases the pages written before cause fails a sync
+func erasePages(store BlockStore, written []int, cause error) error {
+	for _, idx := range written {
+		if err := eraseChain(store, idx); err != nil {
+			return fmt.Errorf("%w, erase written pages failed: %s", cause, err.Error())
+		}
+	}
+	return cause
+}
+
+type treeMeta struct {
+	total    uint16
+	overflow int
+	root     int
+	cmp      string
+	ttl      int
+	seq      uint64
+}
+
+func encodeMeta(magic [4]byte, m treeMeta) ([]byte, error) {
+	if len(m.cmp) > 255 {
+		return nil, fmt.Errorf("%w: comparator name %s is too long", ErrComparator, m.cmp)
+	}
+	data := make([]byte, treeMetaSize, treeMetaSize+len(m.cmp))
+	copy(data[0:4], magic[:])
+	binary.BigEndian.PutUint16(data[4:6], m.total)
+	binary.BigEndian.PutUint32(data[6:10], uint32(m.overflow))
+	binary.BigEndian.PutUint32(data[10:14], uint32(m.root))
+	data[14] = byte(len(m.cmp))
+	data = append(data, m.cmp...)
+	tail := make([]byte, 12)
+	binary.BigEndian.PutUint32(tail[0:4], uint32(m.ttl))
+	binary.BigEndian.PutUint64(tail[4:12], m.seq)
+	return append(data, tail...), nil
+}
+
+// readMeta reads the meta block meta of a tree whose pages begin with magic
+func readMeta(store BlockStore, meta int, magic [4]byte) (treeMeta, error) {
+	var buf bytes.Buffer
+	if _, err := store.WriteTo(&buf, meta); err != nil {
+		return treeMeta{}, err
+	}
+	bs := buf.Bytes()
+	if len(bs) < treeMetaSize || !bytes.Equal(bs[0:4], magic[:]) || len(bs) < treeMetaSize+int(bs[14]) {
+		return treeMeta{}, &PageError{Index: meta, Err: ErrCorrupt}
+	}
+	m := treeMeta{
+		total:    binary.BigEndian.Uint16(bs[4:6]),
+		overflow: int(binary.BigEndian.Uint32(bs[6:10])),
+		root:     int(binary.BigEndian.Uint32(bs[10:14])),
+		cmp:      string(bs[treeMetaSize : treeMetaSize+int(bs[14])]),
+	}
+	rest := bs[treeMetaSize+int(bs[14]):]
+	if len(rest) >= 4 {
+		m.ttl = int(binary.BigEndian.Uint32(rest[0:4]))
+	}
+	if len(rest) >= 12 {
+		m.seq = binary.BigEndian.Uint64(rest[4:12])
+	}
+	return m, nil
+}
+
+// syncMeta rewrites the meta block in place if the store can, otherwise the
+// meta is put into a new block and the old one is erased. It returns the
+// index of the meta block
+func syncMeta(store BlockStore, meta int, data []byte) (int, error) {
+	if rw, ok := store.(Rewriter); ok && meta != 0 {
+		return meta, rw.Rewrite([]Block{{Type: TypeSingle, idx: meta, Data: data}})
+	}
+	blocks, err := store.Acquire(len(data))
+	if err != nil {
+		return meta, err
+	}
+	blocks[0].Data = data
+	if err := store.Put(blocks); err != nil {
+		return meta, err
+	}
+	if meta == 0 {
+		return blocks[0].Index(), nil
+	}
+	return blocks[0].Index(), store.Erase(meta)
+}
+
+// OpenTree loads the tree whose meta block is meta from store, the comparator
+// is resolved by the name persisted in the meta block
+func OpenTree(store BlockStore, meta int) (*btree, error) {
+	return openTree(store, meta, LookupComparator)
+}
+
+// OpenTreeWith loads the tree like OpenTree, but rejects it if it wasn't
+// created with comparator c
+func OpenTreeWith(store BlockStore, meta int, c Comparator) (*btree, error) {
+	return openTree(store, meta, func(name string) (Comparator, error) {
+		if name != c.Name {
+			return c, fmt.Errorf("%w: tree is created with %s, not %s", ErrComparator, name, c.Name)
+		}
+		return c, nil
+	})
+}
+
+func openTree(store BlockStore, meta int, resolve func(name string) (Comparator, error)) (*btree, error) {
+	m, err := readMeta(store, meta, treeMagic)
+	if err != nil {
+		return nil, err
+	}
+	tree := NewStoreTree(m.total, store, m.overflow)
+	tree.meta, tree.seq = meta, m.seq
+	if tree.cmp, err = resolve(m.cmp); err != nil {
+		return nil, err
+	}
+	if m.root != 0 {
+		if tree.root, err = tree.loadNode(m.root, nil); err != nil {
+			return nil, err
+		}
+	}
+	if m.ttl != 0 {
+		deadlines, err := openTree(store, m.ttl, resolve)
+		if err != nil {
+			return nil, err
+		}
+		if tree.ttl, err = loadTTL(tree, deadlines); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+func (tree *btree) loadNode(idx int, p *node) (*node, error) {
+	var buf bytes.Buffer
+	if _, err := tree.store.WriteTo(&buf, idx); err != nil {
+		return nil, err
+	}
+	page, err := decodeNode(buf.Bytes())
+	if err != nil {
+		return nil, &PageError{Index: idx, Err: err}
+	}
+	tree.blocks = append(tree.blocks, idx)
+	tree.prefix = tree.prefix || page.prefix
+	n := &node{p: p, block: idx, tree: tree}
+	if page.first != 0 {
+		if n.first, err = tree.loadNode(page.first, n); err != nil {
+			return nil, err
+		}
+	}
+	n.elems = make(array, len(page.elems))
+	for i, e := range page.elems {
+		el := elem{data: e.data}
+		if e.after != 0 {
+			if el.after, err = tree.loadNode(e.after, n); err != nil {
+				return nil, err
+			}
+		}
+		n.elems[i] = el
+	}
+	n.recount()
+	return n, nil
+}
+
+func (n *node) page() (*nodePage, error) {
+	page := &nodePage{leaf: n.leaf(), prefix: n.tree.prefix, elems: make([]pageElem, len(n.elems))}
+	if n.first != nil {
+		page.first = n.first.block
+	}
+	for i, p := range n.elems {
+		data, ok := p.(elem).data.(pair)
+		if !ok {
+			return nil, fmt.Errorf("%w: only pair can be stored", ErrInvalidBlock)
+		}
+		page.elems[i].data = data
+		if after := p.(elem).after; after != nil {
+			page.elems[i].after = after.block
+		}
+	}
+	return page, nil
+}
+
+func eraseChain(store BlockStore, idx int) error {
+	blocks, err := store.From(idx)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := store.Erase(block.Index()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Test_encodeNode(t *testing.T) {
+	for _, prefix := range []bool{false, true} {
+		page := &nodePage{
+			leaf:   false,
+			prefix: prefix,
+			first:  3,
+			elems: []pageElem{
+				{data: SP("user:000123:email", "a@b.c"), after: 4},
+				{data: SP("user:000123:profile", ""), after: 0},
+				{data: pair{Key: []byte("user:000124:profile"), ref: 9}, after: 5},
+			},
+		}
+		bs := encodeNode(page)
+		decoded, err := decodeNode(bs)
+		if err != nil {
+			t.Fatalf("decode node error: %s", err.Error())
+		}
+		if decoded.leaf != page.leaf || decoded.prefix != prefix || decoded.first != page.first || len(decoded.elems) != len(page.elems) {
+			t.Fatalf("decode node header error")
+		}
+		for i, e := range page.elems {
+			d := decoded.elems[i]
+			if !bytes.Equal(d.data.Key, e.data.Key) || !bytes.Equal(d.data.Val, e.data.Val) || d.data.ref != e.data.ref || d.after != e.after {
+				t.Fatalf("decode element %d error", i)
+			}
+		}
+		if _, err := decodeNode(bs[:len(bs)-3]); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("decode truncated node should be ErrCorrupt")
+		}
+	}
+}
+
+func TestTree_Sync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 32)
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprintf("%03d", i)
+				tree.Put(SP(k, k))
+			}
+			tree.Put(BP([]byte("big"), bytes.Repeat([]byte("v"), 1000)))
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatalf("sync error: %s", err.Error())
+			}
+			tree.Del(SK("050"))
+			if _, err := tree.Sync(); err != nil {
+				t.Fatalf("sync again error: %s", err.Error())
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatalf("open tree error: %s", err.Error())
+			}
+			for i := 0; i < 100; i++ {
+				k := fmt.Sprintf("%03d", i)
+				val, err := loaded.Get(SK(k))
+				if i == 50 {
+					if !errors.Is(err, ErrNotFound) {
+						t.Fatalf("deleted key should not be loaded")
+					}
+					continue
+				}
+				if err != nil || string(val.(pair).Val) != k {
+					t.Fatalf("get %s from loaded tree error", k)
+				}
+			}
+			if val, err := loaded.Get(SK("big")); err != nil || len(val.(pair).Val) != 1000 {
+				t.Fatalf("get overflow value from loaded tree error")
+			}
+			// nodes, the meta block and 2 blocks of the overflow value
+			stats, _ := s.Stats()
+			if stats.Used != len(loaded.blocks)+3 {
+				t.Fatalf("blocks of the last sync should be erased")
+			}
+		})
+	})
+}
+
+func TestTree_SyncPageFits(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(s.DataSize(), s, 0)
+			for i := 0; i < 500; i++ {
+				tree.Put(SP(fmt.Sprintf("key%05d", i), fmt.Sprint(i)))
+			}
+			if _, err := tree.Sync(); err != nil {
+				t.Fatalf("sync error: %s", err.Error())
+			}
+			for _, idx := range tree.blocks {
+				if blocks, err := s.From(idx); err != nil || len(blocks) != 1 {
+					t.Fatalf("node page %d should fit in one block, got %d", idx, len(blocks))
+				}
+			}
+		})
+	})
+}
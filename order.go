@@ -0,0 +1,196 @@
+package inf
+
+// Len returns the number of elements in the tree
+func (tree *btree) Len() int {
+	tree.settle()
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil {
+		return 0
+	}
+	return tree.root.len()
+}
+
+func (tree *btree) Min() (Storeable, error) {
+	if err := tree.settle(); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil {
+		return nil, ErrNotFound
+	}
+	return tree.load(tree.root.edge(func(n *node) int { return 0 }))
+}
+
+func (tree *btree) Max() (Storeable, error) {
+	if err := tree.settle(); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil {
+		return nil, ErrNotFound
+	}
+	return tree.load(tree.root.edge(func(n *node) int { return len(n.elems) }))
+}
+
+// Rank returns the number of elements less than data, whether data is in
+// the tree or not
+func (tree *btree) Rank(data Storeable) int {
+	tree.settle()
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	return tree.rank(data)
+}
+
+// Select returns the ith smallest element, i starts from 0
+func (tree *btree) Select(i int) (Storeable, error) {
+	if err := tree.settle(); err != nil {
+		return nil, err
+	}
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil || i < 0 || i >= tree.root.len() {
+		return nil, ErrNotFound
+	}
+	data := tree.root.selectAt(i)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	return tree.load(data)
+}
+
+// Count returns the number of elements in [start, end)
+func (tree *btree) Count(start, end Storeable) int {
+	tree.settle()
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if c := tree.rank(end) - tree.rank(start); c > 0 {
+		return c
+	}
+	return 0
+}
+
+// edge returns the first or the last element under n, child picks the child
+// to follow down to the leaf, 0 for the first and the last otherwise
+func (n *node) edge(child func(n *node) int) Storeable {
+	n.latch.RLock()
+	for {
+		i := child(n)
+		c := n.child(i)
+		if c == nil {
+			if i > 0 {
+				i--
+			}
+			data := n.elems[i].(elem).data
+			n.latch.RUnlock()
+			return data
+		}
+		c.latch.RLock()
+		n.latch.RUnlock()
+		n = c
+	}
+}
+
+func (tree *btree) rank(data Storeable) int {
+	if tree.root == nil {
+		return 0
+	}
+	rank := 0
+	n := tree.root
+	n.latch.RLock()
+	for {
+		pos, exactly := n.shouldBe(elem{data: data})
+		for i := 0; i < pos; i++ {
+			rank += n.childCount(i) + 1
+		}
+		if exactly {
+			rank += n.childCount(pos)
+			n.latch.RUnlock()
+			return rank
+		}
+		c := n.child(pos)
+		if c == nil {
+			n.latch.RUnlock()
+			return rank
+		}
+		c.latch.RLock()
+		n.latch.RUnlock()
+		n = c
+	}
+}
+
+// seek returns the smallest element not less than data under one lock of
+// the tree, nodes are read under their latches so latched writers proceed
+func (tree *btree) seek(data Storeable) (Storeable, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	if tree.root == nil {
+		return nil, ErrNotFound
+	}
+	var found Storeable
+	n := tree.root
+	n.latch.RLock()
+	for {
+		pos, exactly := n.shouldBe(elem{data: data})
+		if exactly {
+			found = n.elems[pos].(elem).data
+			n.latch.RUnlock()
+			break
+		}
+		if pos < len(n.elems) {
+			found = n.elems[pos].(elem).data
+		}
+		c := n.child(pos)
+		if c == nil {
+			n.latch.RUnlock()
+			break
+		}
+		c.latch.RLock()
+		n.latch.RUnlock()
+		n = c
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return tree.load(found)
+}
+
+func (n *node) childCount(i int) int {
+	if c := n.child(i); c != nil {
+		return c.len()
+	}
+	return 0
+}
+
+// selectAt returns the ith element under n, nil if latched writers change
+// the counts on the way
+func (n *node) selectAt(i int) Storeable {
+	n.latch.RLock()
+	return n.selectLatched(i)
+}
+
+func (n *node) selectLatched(i int) Storeable {
+	for j := 0; j <= len(n.elems); j++ {
+		c := n.childCount(j)
+		if i < c {
+			child := n.child(j)
+			child.latch.RLock()
+			n.latch.RUnlock()
+			return child.selectLatched(i)
+		}
+		i -= c
+		if j == len(n.elems) {
+			break
+		}
+		if i == 0 {
+			data := n.elems[j].(elem).data
+			n.latch.RUnlock()
+			return data
+		}
+		i--
+	}
+	n.latch.RUnlock()
+	return nil
+}
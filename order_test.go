@@ -0,0 +1,71 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestTree_order(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(32)
+		keys := map[string]bool{}
+		for i := 0; i < 300; i++ {
+			k := fmt.Sprintf("%03d", r.Intn(100))
+			if r.Intn(3) == 0 {
+				if err := tree.Del(SK(k)); (err == nil) != keys[k] {
+					t.Fatalf("seed %d del %s error: %v", seed, k, err)
+				}
+				delete(keys, k)
+			} else {
+				tree.Put(SP(k, k))
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			if tree.Len() != len(sorted) {
+				t.Fatalf("seed %d step %d len %d should be %d", seed, i, tree.Len(), len(sorted))
+			}
+			for rank, k := range sorted {
+				if _, err := tree.Get(SK(k)); err != nil {
+					t.Fatalf("seed %d step %d lost %s", seed, i, k)
+				}
+				if tree.Rank(SK(k)) != rank {
+					t.Fatalf("seed %d step %d rank of %s should be %d", seed, i, k, rank)
+				}
+				if data, err := tree.Select(rank); err != nil || string(data.(pair).Key) != k {
+					t.Fatalf("seed %d step %d select %d should be %s", seed, i, rank, k)
+				}
+			}
+		}
+	}
+}
+
+func TestTree_minMaxCount(t *testing.T) {
+	tree := NewTree(32)
+	if _, err := tree.Min(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("min of empty tree should be ErrNotFound")
+	}
+	tree = createTree()
+	if min, _ := tree.Min(); string(min.(pair).Key) != "00" {
+		t.Fatalf("min should be 00")
+	}
+	if max, _ := tree.Max(); string(max.(pair).Key) != "10" {
+		t.Fatalf("max should be 10")
+	}
+	if c := tree.Count(SK("03"), SK("07")); c != 4 {
+		t.Fatalf("count [03, 07) should be 4, got %d", c)
+	}
+	if c := tree.Count(SK("025"), SK("99")); c != 8 {
+		t.Fatalf("count [025, 99) should be 8, got %d", c)
+	}
+	if _, err := tree.Select(11); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("select out of range should be ErrNotFound")
+	}
+}
@@ -0,0 +1,118 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestTree_prefixCompression(t *testing.T) {
+	for seed := int64(0); seed < 30; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(64)
+		if err := tree.EnablePrefixCompression(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 400; i++ {
+			k := fmt.Sprintf("user:%0*d:profile", 1+r.Intn(6), r.Intn(200))
+			if r.Intn(3) == 0 {
+				tree.Del(SK(k))
+			} else {
+				tree.Put(SP(k, fmt.Sprint(i)))
+			}
+			if err := tree.Check(); err != nil {
+				t.Fatalf("seed %d step %d: %s", seed, i, err.Error())
+			}
+		}
+	}
+	if err := createTree().EnablePrefixCompression(); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("enable prefix compression on non empty tree should be ErrNotEmpty")
+	}
+}
+
+func TestTree_prefixComparator(t *testing.T) {
+	for seed := int64(0); seed < 30; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(40)
+		tree.SetComparator(CaseInsensitiveComparator)
+		if err := tree.EnablePrefixCompression(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 400; i++ {
+			k := make([]byte, 1+r.Intn(8))
+			for j := range k {
+				k[j] = "aAbB"[r.Intn(4)]
+			}
+			if r.Intn(3) == 0 {
+				tree.Del(BK(k))
+			} else {
+				tree.Put(BP(k, []byte("v")))
+			}
+			if err := tree.Check(); err != nil {
+				t.Fatalf("seed %d step %d: %s", seed, i, err.Error())
+			}
+		}
+	}
+}
+
+func TestTree_prefixFanout(t *testing.T) {
+	plain, compressed := NewTree(128), NewTree(128)
+	compressed.EnablePrefixCompression()
+	for i := 0; i < 500; i++ {
+		data := SP(fmt.Sprintf("user:%06d:profile", i), "v")
+		plain.Put(data)
+		compressed.Put(data)
+	}
+	if countNodes(compressed.root) >= countNodes(plain.root) {
+		t.Fatalf("compressed tree should use less nodes, %d >= %d", countNodes(compressed.root), countNodes(plain.root))
+	}
+}
+
+func TestTree_prefixSync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(128, s, 0)
+			tree.EnablePrefixCompression()
+			for i := 0; i < 200; i++ {
+				tree.Put(SP(fmt.Sprintf("user:%06d:profile", i), "v"))
+			}
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.prefix {
+				t.Fatalf("loaded tree should have prefix compression")
+			}
+			if err := loaded.Check(); err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Len() != 200 {
+				t.Fatalf("loaded tree should have 200 keys, got %d", loaded.Len())
+			}
+		})
+	})
+}
+
+func TestBPlusTree_separator(t *testing.T) {
+	tree := NewBPlusTree(64)
+	cases := []struct{ last, first, sep string }{
+		{"user:000123:profile", "user:000124:profile", "user:000124"},
+		{"abc", "abd", "abd"},
+		{"ab", "abc", "abc"},
+		{"apple", "banana", "b"},
+	}
+	for _, c := range cases {
+		if sep := tree.separator(SK(c.last), SK(c.first)); string(sep.(pair).Key) != c.sep {
+			t.Fatalf("separator of %s %s should be %s, got %s", c.last, c.first, c.sep, sep.(pair).Key)
+		}
+	}
+	tree.SetComparator(CaseInsensitiveComparator)
+	if sep := tree.separator(SK("apple"), SK("banana")); string(sep.(pair).Key) != "banana" {
+		t.Fatalf("separator should not be truncated with a custom comparator")
+	}
+}
@@ -0,0 +1,199 @@
+package inf
+
+import (
+	"math"
+)
+
+// DeleteRange deletes the elements in [start, end), it returns the number of
+// elements deleted. The tree is cut at start and at end, the subtrees between
+// are dropped as a whole instead of rebalanced element by element, then the
+// two sides are joined. The block chains of the dropped values are erased
+// one by one after the tree is changed. Every dropped key is recorded for
+// watchers, indexes and versions even if erasing a chain fails, the first
+// error is returned with the number of elements deleted
+func (tree *btree) DeleteRange(start, end Storeable) (int, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.compare(elem{data: start}, elem{data: end}) >= 0 {
+		return 0, nil
+	}
+	var dropped []Storeable
+	if tree.root != nil {
+		l, rest := tree.root.cut(start)
+		var mid, r *node
+		if rest != nil {
+			mid, r = rest.cut(end)
+		}
+		tree.root = tree.concat(l, r)
+		mid.each(func(data Storeable) {
+			dropped = append(dropped, data)
+		})
+	}
+	var err error
+	for _, data := range dropped {
+		if e := tree.release(data); e != nil && err == nil {
+			err = e
+		}
+	}
+	for _, data := range dropped {
+		if e := tree.record(data, true); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return len(dropped), err
+	}
+	merged, err := tree.delMergedRange(start, end)
+	return len(dropped) + merged, err
+}
+
+// delMergedRange deletes the keys in [start, end) which have merge operands
+// only
+func (tree *btree) delMergedRange(start, end Storeable) (int, error) {
+	sp, sok := start.(pair)
+	ep, eok := end.(pair)
+	if tree.deltas == nil || !sok || !eok {
+		return 0, nil
+	}
+	cmp := tree.Comparator().Compare
+	var keys [][]byte
+	v := keyVersion{key: sp.Key, seq: math.MaxUint64, cmp: cmp}
+	for i := tree.deltas.Rank(v); ; i++ {
+		found, err := tree.deltas.Select(i)
+		if err != nil || cmp(found.(keyVersion).key, ep.Key) >= 0 {
+			break
+		}
+		key := found.(keyVersion).key
+		if l := len(keys); l == 0 || cmp(keys[l-1], key) != 0 {
+			keys = append(keys, key)
+		}
+	}
+	for i, key := range keys {
+		if err := tree.record(BK(key), true); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// cut splits the subtree n into a tree of the elements before data and a tree
+// of the rest. The nodes on the path to data are replaced, the subtrees beside
+// the path are moved as they are
+func (n *node) cut(data Storeable) (l, r *node) {
+	pos, _ := n.shouldBe(elem{data: data})
+	var cl, cr *node
+	if c := n.child(pos); c != nil {
+		cl, cr = c.cut(data)
+	}
+	l, r = cl, cr
+	if pos > 0 {
+		l = n.tree.join(n.part(0, pos-1), n.elems[pos-1].(elem).data, cl)
+	}
+	if pos < len(n.elems) {
+		r = n.tree.join(cr, n.elems[pos].(elem).data, n.part(pos+1, len(n.elems)))
+	}
+	return
+}
+
+// part returns a tree of the elements [from, to) of n and the children around
+func (n *node) part(from, to int) *node {
+	if from == to {
+		return n.child(from).detach()
+	}
+	p := &node{tree: n.tree, first: n.child(from), elems: append(array{}, n.elems[from:to]...), gen: n.tree.gen}
+	p.adopt()
+	// the first element loses the prefix it shared
+	return p.popup()
+}
+
+// detach makes n the root of a tree on its own, it's copied if it's shared
+// with snapshots
+func (n *node) detach() *node {
+	if n == nil {
+		return nil
+	}
+	n.p = nil
+	tree := n.tree
+	if n.gen == tree.gen || len(tree.snapshots) == 0 {
+		n.gen = tree.gen
+		return n
+	}
+	c := &node{elems: append(array{}, n.elems...), first: n.first, tree: tree, count: n.count, gen: tree.gen}
+	c.adopt()
+	return c
+}
+
+// join returns a tree of the elements of l, data and the elements of r. The
+// lower tree is hung under the edge of the higher one as the child after or
+// before data
+func (tree *btree) join(l *node, data Storeable, r *node) *node {
+	hl, hr := l.height(), r.height()
+	switch {
+	case hl == hr:
+		n := &node{tree: tree, first: l, elems: array{elem{data: data, after: r}}, gen: tree.gen}
+		n.adopt()
+		n.recount()
+		return n
+	case hl > hr:
+		m := l
+		for h := hl; h > hr+1; h-- {
+			m = m.child(len(m.elems))
+		}
+		m = m.own()
+		m.elems = append(m.elems, elem{data: data, after: r})
+		if r != nil {
+			r.p = m
+		}
+		return m.popup()
+	default:
+		m := r
+		for h := hr; h > hl+1; h-- {
+			m = m.first
+		}
+		m = m.own()
+		m.elems.insertAt(0, elem{data: data, after: m.first})
+		m.first = l
+		if l != nil {
+			l.p = m
+		}
+		return m.popup()
+	}
+}
+
+// concat joins l and r with the last element of l in between
+func (tree *btree) concat(l, r *node) *node {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	leaf := l.rightmost().own()
+	last := len(leaf.elems) - 1
+	data := leaf.elems[last].(elem).data
+	if l = leaf.delAt(last); len(l.elems) == 0 {
+		l = l.first.detach()
+	}
+	return tree.join(l, data, r)
+}
+
+// height returns the number of levels of the subtree n
+func (n *node) height() int {
+	h := 0
+	for c := n; c != nil; c = c.first {
+		h++
+	}
+	return h
+}
+
+// each calls fn with the elements of the subtree n in order
+func (n *node) each(fn func(data Storeable)) {
+	if n == nil {
+		return
+	}
+	n.first.each(fn)
+	for _, p := range n.elems {
+		fn(p.(elem).data)
+		p.(elem).after.each(fn)
+	}
+}
@@ -0,0 +1,165 @@
+package inf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func treeKeys(t *testing.T, tree *btree) []string {
+	keys := []string{}
+	for i := 0; i < tree.Len(); i++ {
+		data, err := tree.Select(i)
+		if err != nil {
+			t.Fatalf("select %d error: %s", i, err)
+		}
+		keys = append(keys, string(data.(pair).Key))
+	}
+	return keys
+}
+
+func TestTree_DeleteRange(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(32)
+		if seed%2 == 1 {
+			tree.EnablePrefixCompression()
+		}
+		model := map[string]bool{}
+		for step := 0; step < 20; step++ {
+			for i := 0; i < 50; i++ {
+				k := fmt.Sprintf("%03d", r.Intn(300))
+				tree.Put(SP(k, strings.Repeat("v", r.Intn(5))))
+				model[k] = true
+			}
+			start, end := fmt.Sprintf("%03d", r.Intn(300)), fmt.Sprintf("%03d", r.Intn(300))
+			deleted := 0
+			for k := range model {
+				if k >= start && k < end {
+					delete(model, k)
+					deleted++
+				}
+			}
+			var snap *Snapshot
+			var before []string
+			if seed%3 == 0 {
+				snap = tree.Snapshot()
+				before = snapshotKeys(t, snap)
+			}
+			n, err := tree.DeleteRange(SK(start), SK(end))
+			if snap != nil {
+				if after := snapshotKeys(t, snap); fmt.Sprint(after) != fmt.Sprint(before) {
+					t.Fatalf("seed %d step %d: snapshot changed", seed, step)
+				}
+				snap.Close()
+			}
+			if err != nil || n != deleted {
+				t.Fatalf("seed %d step %d: delete [%s, %s) got %d, %v, want %d", seed, step, start, end, n, err, deleted)
+			}
+			if err := tree.Check(); err != nil {
+				var buf bytes.Buffer
+				tree.Dump(&buf)
+				t.Fatalf("seed %d step %d: %v\n%s", seed, step, err, buf.String())
+			}
+			want := []string{}
+			for k := range model {
+				want = append(want, k)
+			}
+			sort.Strings(want)
+			if got := treeKeys(t, tree); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("seed %d step %d: keys %v, want %v", seed, step, got, want)
+			}
+		}
+	}
+}
+
+func TestTree_DeleteRangeSnapshot(t *testing.T) {
+	tree := NewTree(32)
+	for i := 0; i < 200; i++ {
+		tree.Put(SP(fmt.Sprintf("%03d", i), "v"))
+	}
+	snap := tree.Snapshot()
+	defer snap.Close()
+	if n, _ := tree.DeleteRange(SK("050"), SK("150")); n != 100 {
+		t.Fatalf("delete range should delete 100 keys, got %d", n)
+	}
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 100 || snap.Len() != 200 || len(snapshotKeys(t, snap)) != 200 {
+		t.Fatalf("snapshot should not see the deleted range")
+	}
+	if _, err := snap.Get(SK("100")); err != nil {
+		t.Fatalf("snapshot should read a deleted key")
+	}
+}
+
+func TestTree_DeleteRangeHooks(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			tree.SetMergeOperator(AppendOperator)
+			tree.AddIndex("val", func(key, val []byte) [][]byte {
+				return [][]byte{val[:1]}
+			})
+			long := bytes.Repeat([]byte("v"), 100)
+			for i := 0; i < 20; i++ {
+				tree.Put(BP([]byte(fmt.Sprintf("%03d", i)), long))
+			}
+			tree.Merge(SP("0105", "x"))
+			w, err := tree.Watch(nil, tree.Seq())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			stats, _ := s.Stats()
+			before := stats.Used
+			if n, err := tree.DeleteRange(SK("005"), SK("015")); err != nil || n != 11 {
+				t.Fatalf("delete range should delete 11 keys, got %d, %v", n, err)
+			}
+			if stats, _ := s.Stats(); stats.Used >= before {
+				t.Fatalf("value chains should be erased")
+			}
+			if e := nextEvent(t, w); string(e.Key) != "005" || !e.Del {
+				t.Fatalf("delete range should be watched, got %v", e)
+			}
+			if keys, _ := tree.Index("val").Lookup([]byte("v")); len(keys) != 10 {
+				t.Fatalf("index should drop deleted keys, got %d", len(keys))
+			}
+			if _, err := tree.Get(SK("0105")); err == nil {
+				t.Fatalf("key with operands only should be deleted")
+			}
+		})
+	})
+}
+
+func TestTree_DeleteRangeEraseFailed(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			idx, _ := tree.AddIndex("val", func(key, val []byte) [][]byte {
+				return [][]byte{val[:1]}
+			})
+			long := bytes.Repeat([]byte("v"), 100)
+			for i := 0; i < 20; i++ {
+				tree.Put(BP([]byte(fmt.Sprintf("%03d", i)), long))
+			}
+			seq := tree.Seq()
+			s.readonly = true
+			n, err := tree.DeleteRange(SK("005"), SK("015"))
+			if !errors.Is(err, ErrReadOnly) || n != 10 {
+				t.Fatalf("delete range should delete 10 keys and fail to erase, got %d, %v", n, err)
+			}
+			if tree.Seq() != seq+10 {
+				t.Fatalf("every deleted key should be recorded, seq %d should be %d", tree.Seq(), seq+10)
+			}
+			if keys, _ := idx.Lookup([]byte("v")); len(keys) != 10 {
+				t.Fatalf("index should drop deleted keys, got %d", len(keys))
+			}
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package inf
+
+// Snapshot is an immutable view of a tree at the time it's taken. Reading
+// a snapshot doesn't lock the tree, writers copy the nodes shared with open
+// snapshots before changing them. Value chains replaced or deleted while a
+// snapshot is open are erased when the last snapshot is closed
+type Snapshot struct {
+	tree   *btree
+	root   *node
+	seq    uint64
+	closed bool
+}
+
+// Snapshot takes a snapshot of the tree, expired keys are swept and merge
+// operands are compacted first
+func (tree *btree) Snapshot() *Snapshot {
+	tree.settle()
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	tree.gen++
+	s := &Snapshot{tree: tree, root: tree.root, seq: tree.seq}
+	if tree.snapshots == nil {
+		tree.snapshots = map[*Snapshot]struct{}{}
+	}
+	tree.snapshots[s] = struct{}{}
+	return s
+}
+
+// Seq returns the sequence number of the last write seen by the snapshot
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
+
+func (s *Snapshot) Get(data Storeable) (Storeable, error) {
+	if s.root == nil {
+		return nil, ErrNotFound
+	}
+	ret, err := s.root.get(data)
+	if err != nil {
+		return nil, err
+	}
+	return s.tree.load(ret)
+}
+
+func (s *Snapshot) Len() int {
+	if s.root == nil {
+		return 0
+	}
+	return s.root.len()
+}
+
+// Iterator iterates over the elements of the snapshot in order
+func (s *Snapshot) Iterator() Iterator {
+	it := &nodeIterator{tree: s.tree}
+	it.descend(s.root)
+	return it
+}
+
+// Close releases the snapshot, it must not be read after
+func (s *Snapshot) Close() error {
+	tree := s.tree
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	delete(tree.snapshots, s)
+	if len(tree.snapshots) > 0 {
+		return nil
+	}
+	released := tree.released
+	tree.released = nil
+	for _, idx := range released {
+		if err := eraseChain(tree.store, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type nodeFrame struct {
+	n   *node
+	pos int // next element of n
+}
+
+// nodeIterator walks nodes in order with a stack of the nodes on the path
+type nodeIterator struct {
+	tree  *btree
+	stack []nodeFrame
+	data  Storeable
+	err   error
+}
+
+func (it *nodeIterator) descend(n *node) {
+	for ; n != nil; n = n.first {
+		it.stack = append(it.stack, nodeFrame{n: n})
+	}
+}
+
+func (it *nodeIterator) Next() bool {
+	for it.err == nil && len(it.stack) > 0 {
+		top := &it.stack[len(it.stack)-1]
+		if top.pos >= len(top.n.elems) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		p := top.n.elems[top.pos].(elem)
+		top.pos++
+		it.descend(p.after)
+		if it.data, it.err = it.tree.load(p.data); it.err != nil {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (it *nodeIterator) Data() Storeable {
+	return it.data
+}
+
+func (it *nodeIterator) Err() error {
+	return it.err
+}
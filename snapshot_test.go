@@ -0,0 +1,149 @@
+package inf
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func snapshotKeys(t *testing.T, s *Snapshot) []string {
+	keys := []string{}
+	it := s.Iterator()
+	for it.Next() {
+		keys = append(keys, string(it.Data().(pair).Key))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterate snapshot error: %s", err.Error())
+	}
+	return keys
+}
+
+func TestTree_Snapshot(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tree := NewTree(32)
+		keys := map[string]bool{}
+		var snap *Snapshot
+		var want []string
+		for i := 0; i < 300; i++ {
+			if i%50 == 0 {
+				if snap != nil {
+					if got := snapshotKeys(t, snap); fmt.Sprint(got) != fmt.Sprint(want) {
+						t.Fatalf("seed %d step %d snapshot changed\n%v\n%v", seed, i, got, want)
+					}
+					snap.Close()
+				}
+				snap = tree.Snapshot()
+				want = make([]string, 0, len(keys))
+				for k := range keys {
+					want = append(want, k)
+				}
+				sort.Strings(want)
+			}
+			k := fmt.Sprintf("%03d", r.Intn(100))
+			if r.Intn(3) == 0 {
+				tree.Del(SK(k))
+				delete(keys, k)
+			} else {
+				tree.Put(SP(k, fmt.Sprint(i)))
+				keys[k] = true
+			}
+			if err := tree.Check(); err != nil {
+				t.Fatalf("seed %d step %d: %s", seed, i, err.Error())
+			}
+		}
+		if snap.Len() != len(want) {
+			t.Fatalf("seed %d snapshot len %d should be %d", seed, snap.Len(), len(want))
+		}
+		for _, k := range want {
+			if _, err := snap.Get(SK(k)); err != nil {
+				t.Fatalf("seed %d snapshot lost %s", seed, k)
+			}
+		}
+		snap.Close()
+	}
+}
+
+func TestTree_SnapshotValue(t *testing.T) {
+	tree := createTree()
+	snap := tree.Snapshot()
+	defer snap.Close()
+	tree.Put(SP("05", "new"))
+	if data, _ := snap.Get(SK("05")); string(data.(pair).Val) != "05" {
+		t.Fatalf("snapshot value should not change")
+	}
+	if data, _ := tree.Get(SK("05")); string(data.(pair).Val) != "new" {
+		t.Fatalf("tree value should change")
+	}
+}
+
+func TestTree_SnapshotConcurrent(t *testing.T) {
+	tree := NewTree(64)
+	for _, item := range sortedItems(500) {
+		tree.Put(item)
+	}
+	snap := tree.Snapshot()
+	defer snap.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if keys := snapshotKeys(t, snap); len(keys) != 500 {
+					t.Errorf("snapshot should have 500 keys, got %d", len(keys))
+				}
+			}
+		}()
+	}
+	for i := 0; i < 500; i++ {
+		k := fmt.Sprintf("%04d", i)
+		if i%2 == 0 {
+			tree.Del(SK(k))
+		} else {
+			tree.Put(SP(k, "new"))
+		}
+	}
+	wg.Wait()
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != 250 {
+		t.Fatalf("tree should have 250 keys, got %d", tree.Len())
+	}
+}
+
+func TestTree_SnapshotRelease(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			tree := NewStoreTree(64, s, 8)
+			long := bytes.Repeat([]byte("v"), 100)
+			tree.Put(BP([]byte("a"), long))
+			snap := tree.Snapshot()
+			used := func() int {
+				stats, err := s.Stats()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return stats.Used
+			}
+			before := used()
+			tree.Del(SK("a"))
+			if used() != before {
+				t.Fatalf("value chain should be kept while snapshot is open")
+			}
+			if data, err := snap.Get(SK("a")); err != nil || !bytes.Equal(data.(pair).Val, long) {
+				t.Fatalf("snapshot should read the deleted value")
+			}
+			if err := snap.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if used() >= before {
+				t.Fatalf("value chain should be erased after snapshot is closed")
+			}
+		})
+	})
+}
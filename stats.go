@@ -0,0 +1,159 @@
+package inf
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// Hook receives io events of a block store, readers share the store lock
+// so it may be called concurrently and must be safe for concurrent use.
+// Implementations should be cheap, e.g. bumping prometheus counters
+type Hook interface {
+	PageRead(idx, n int)
+	PageWritten(idx, n int)
+	PageErased(idx int)
+	MetaSynced(n int)
+}
+
+type counters struct {
+	reads        uint64
+	writes       uint64
+	erases       uint64
+	syncs        uint64
+	bytesRead    uint64
+	bytesWritten uint64
+	hook         Hook
+}
+
+type Stats struct {
+	BlockSize     int
+	DataSize      int
+	Total         int // pages except the super block
+	Used          int
+	Free          int
+	UsedBytes     int64 // user data bytes in used pages
+	FreeBytes     int64
+	FreeListLen   int
+	Fragmentation float64     // free pages in total pages
+	Chains        map[int]int // chain length -> value count
+
+	Reads        uint64
+	Writes       uint64
+	Erases       uint64
+	Syncs        uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+func (c *counters) read(idx, n int) {
+	atomic.AddUint64(&c.reads, 1)
+	atomic.AddUint64(&c.bytesRead, uint64(n))
+	if c.hook != nil {
+		c.hook.PageRead(idx, n)
+	}
+}
+
+func (c *counters) write(idx, n int) {
+	atomic.AddUint64(&c.writes, 1)
+	atomic.AddUint64(&c.bytesWritten, uint64(n))
+	if c.hook != nil {
+		c.hook.PageWritten(idx, n)
+	}
+}
+
+func (c *counters) erase(idx int) {
+	atomic.AddUint64(&c.erases, 1)
+	if c.hook != nil {
+		c.hook.PageErased(idx)
+	}
+}
+
+func (c *counters) sync(n int) {
+	atomic.AddUint64(&c.syncs, 1)
+	atomic.AddUint64(&c.bytesWritten, uint64(n))
+	if c.hook != nil {
+		c.hook.MetaSynced(n)
+	}
+}
+
+// SetHook sets the hook receiving io events, it should be called before
+// Create or Open
+func (s *blockStore) SetHook(hook Hook) {
+	s.counters.hook = hook
+}
+
+// Stats scans all pages of the store, it takes the store lock during the
+// whole scan
+func (s *blockStore) Stats() (stats Stats, err error) {
+	err = s.ensure(func() error {
+		stats = Stats{
+			BlockSize:    int(s.blockSize),
+			DataSize:     int(s.DataSize()),
+			Total:        s.total,
+			Chains:       map[int]int{},
+			Reads:        atomic.LoadUint64(&s.counters.reads),
+			Writes:       atomic.LoadUint64(&s.counters.writes),
+			Erases:       atomic.LoadUint64(&s.counters.erases),
+			Syncs:        atomic.LoadUint64(&s.counters.syncs),
+			BytesRead:    atomic.LoadUint64(&s.counters.bytesRead),
+			BytesWritten: atomic.LoadUint64(&s.counters.bytesWritten),
+		}
+		nexts := map[int]int{}
+		pointed := map[int]bool{}
+		bs := s.pagePool.Get().([]byte)
+		defer s.pagePool.Put(bs)
+		for idx := 1; idx <= s.total; idx++ {
+			if n, err := s.readAt(bs[:7], idx); n < 7 {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return &PageError{Index: idx, Err: err}
+			}
+			t := Type(bs[0] & 0x0f)
+			if t == TypeEmpty {
+				stats.Free++
+				continue
+			}
+			stats.Used++
+			stats.UsedBytes += int64(binary.BigEndian.Uint16(bs[1:3]))
+			if t != TypeChained {
+				nexts[idx] = 0
+				continue
+			}
+			next := int(binary.BigEndian.Uint32(bs[3:7]))
+			nexts[idx] = next
+			if next != 0 {
+				pointed[next] = true
+			}
+		}
+		if s.crypt != nil {
+			stats.UsedBytes -= int64(stats.Used * cryptOverhead)
+		}
+		stats.FreeBytes = int64(stats.Free) * int64(stats.DataSize)
+		for idx := s.freeHead; idx != 0 && stats.FreeListLen <= stats.Free; stats.FreeListLen++ {
+			var err error
+			if idx, err = s.nextFreeBlock(idx); err != nil {
+				return err
+			}
+		}
+		if stats.FreeListLen != stats.Free {
+			return &PageError{Index: s.freeHead, Err: ErrBadFreeList}
+		}
+		if s.total > 0 {
+			stats.Fragmentation = float64(stats.Free) / float64(s.total)
+		}
+		for idx := range nexts {
+			if pointed[idx] {
+				continue
+			}
+			length := 1
+			for next := nexts[idx]; next != 0 && length <= stats.Used; next = nexts[next] {
+				length++
+			}
+			stats.Chains[length]++
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,55 @@
+package inf
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+type countHook struct {
+	reads, writes, erases, syncs int64
+}
+
+func (h *countHook) PageRead(idx, n int)    { atomic.AddInt64(&h.reads, 1) }
+func (h *countHook) PageWritten(idx, n int) { atomic.AddInt64(&h.writes, 1) }
+func (h *countHook) PageErased(idx int)     { atomic.AddInt64(&h.erases, 1) }
+func (h *countHook) MetaSynced(n int)       { atomic.AddInt64(&h.syncs, 1) }
+
+func Test_blockStore_Stats(t *testing.T) {
+	cleanup(func() {
+		hook := &countHook{}
+		s := New(FileRWSC("./block.fsf"))
+		s.SetHook(hook)
+		if err := s.Create(V010000, 512); err != nil {
+			t.Fatalf("create error: %s", err.Error())
+		}
+		defer s.Close()
+		if _, err := s.Store(make([]byte, 1200), CodecNone); err != nil {
+			t.Fatalf("store error: %s", err.Error())
+		}
+		if _, err := s.Store([]byte("hello"), CodecNone); err != nil {
+			t.Fatalf("store error: %s", err.Error())
+		}
+		if err := s.Erase(4); err != nil {
+			t.Fatalf("erase error: %s", err.Error())
+		}
+		stats, err := s.Stats()
+		if err != nil {
+			t.Fatalf("stats error: %s", err.Error())
+		}
+		if stats.Total != 4 || stats.Used != 3 || stats.Free != 1 || stats.FreeListLen != 1 {
+			t.Fatalf("page counts error: %+v", stats)
+		}
+		if stats.UsedBytes != 1200 || stats.FreeBytes != int64(s.DataSize()) {
+			t.Fatalf("bytes error: %+v", stats)
+		}
+		if stats.Fragmentation != 0.25 {
+			t.Fatalf("fragmentation error: %+v", stats)
+		}
+		if len(stats.Chains) != 1 || stats.Chains[3] != 1 {
+			t.Fatalf("chain histogram error: %+v", stats.Chains)
+		}
+		if atomic.LoadInt64(&hook.writes) != int64(stats.Writes) || atomic.LoadInt64(&hook.erases) != 1 || atomic.LoadInt64(&hook.syncs) != int64(stats.Syncs) {
+			t.Fatalf("hook should receive io events")
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package inf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var errExpired = fmt.Errorf("%w: key is expired", ErrNotFound)
+
+// ttlIndex keeps the deadlines of keys by key, and by deadline so sweeping
+// only visits the expired keys. The deadlines of a tree with a store are
+// synced with the tree, the order by deadline is rebuilt when it's opened
+type ttlIndex struct {
+	deadlines *btree // key to deadline, ordered by the comparator of the tree
+	expiries  *btree // keys made of deadline and key by tupleKey
+}
+
+// deadlineTree creates the tree of deadlines by key, it's a tree of the store
+// of tree if there is, an element of it is 8 bytes longer than the key
+func (tree *btree) deadlineTree() *btree {
+	total := int(tree.total) + 16
+	if total > math.MaxUint16 {
+		total = math.MaxUint16
+	}
+	deadlines := NewTree(uint16(total))
+	if tree.store != nil {
+		deadlines = NewStoreTree(uint16(total), tree.store, 0)
+	}
+	deadlines.cmp = tree.cmp
+	return deadlines
+}
+
+// loadTTL rebuilds the TTL index of tree from its synced deadlines
+func loadTTL(tree *btree, deadlines *btree) (*ttlIndex, error) {
+	ttl := &ttlIndex{deadlines: deadlines, expiries: tree.tupleTree()}
+	it := &nodeIterator{tree: deadlines}
+	it.descend(deadlines.root)
+	for it.Next() {
+		p := it.Data().(pair)
+		if err := ttl.expiries.Put(BK(tupleKey(p.Val, p.Key))); err != nil {
+			return nil, err
+		}
+	}
+	return ttl, it.Err()
+}
+
+func (tree *btree) now() time.Time {
+	if tree.clock != nil {
+		return tree.clock()
+	}
+	return time.Now()
+}
+
+// PutWithTTL puts data which is invisible to reads after d and deleted
+// lazily by Get or by Sweep. A later put or delete of the key clears its
+// TTL. Len, Min, Max, Rank, Select, Count, Snapshot, GetAt and IteratorAt
+// sweep the expired keys before they answer, so an expiry is a delete with
+// its own sequence number for versions and watchers
+func (tree *btree) PutWithTTL(data Storeable, d time.Duration) error {
+	p, ok := data.(pair)
+	if !ok {
+		return fmt.Errorf("%w: only pair can have TTL", ErrInvalidBlock)
+	}
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if err := tree.put(data); err != nil {
+		return err
+	}
+	if tree.ttl == nil {
+		tree.ttl = &ttlIndex{deadlines: tree.deadlineTree(), expiries: tree.tupleTree()}
+	}
+	deadline := make([]byte, 8)
+	binary.BigEndian.PutUint64(deadline, uint64(tree.now().Add(d).UnixNano()))
+	if err := tree.ttl.deadlines.Put(pair{Key: p.Key, Val: deadline}); err != nil {
+		return err
+	}
+	return tree.ttl.expiries.Put(BK(tupleKey(deadline, p.Key)))
+}
+
+// TTL returns how long data lives, ErrNotFound if it has no TTL
+func (tree *btree) TTL(data Storeable) (time.Duration, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	deadline, ok := tree.deadline(data)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return deadline.Sub(tree.now()), nil
+}
+
+func (tree *btree) deadline(data Storeable) (time.Time, bool) {
+	if tree.ttl == nil {
+		return time.Time{}, false
+	}
+	p, ok := data.(pair)
+	if !ok {
+		return time.Time{}, false
+	}
+	found, err := tree.ttl.deadlines.Get(BK(p.Key))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(found.(pair).Val))), true
+}
+
+func (tree *btree) expired(data Storeable) bool {
+	deadline, ok := tree.deadline(data)
+	return ok && !tree.now().Before(deadline)
+}
+
+// unexpire clears the TTL of data
+func (tree *btree) unexpire(data Storeable) error {
+	if tree.ttl == nil {
+		return nil
+	}
+	p, ok := data.(pair)
+	if !ok {
+		return nil
+	}
+	found, err := tree.ttl.deadlines.Get(BK(p.Key))
+	if err != nil {
+		return nil
+	}
+	fp := found.(pair)
+	if err := tree.ttl.expiries.Del(BK(tupleKey(fp.Val, fp.Key))); err != nil {
+		return err
+	}
+	return tree.ttl.deadlines.Del(BK(fp.Key))
+}
+
+// expire deletes data if it's expired, otherwise data is put again after
+// it's expired and it's returned
+func (tree *btree) expire(data Storeable) (Storeable, error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if !tree.expired(data) {
+		return tree.get(data)
+	}
+	if err := tree.del(data); err != nil {
+		return nil, err
+	}
+	return nil, ErrNotFound
+}
+
+// settle sweeps the expired keys and compacts the merge operands, reads
+// which count or visit keys call it first so they agree with Get
+func (tree *btree) settle() error {
+	if err := tree.sweepDue(); err != nil {
+		return err
+	}
+	return tree.compactDue()
+}
+
+// sweepDue sweeps the expired keys if there are
+func (tree *btree) sweepDue() error {
+	tree.lock.RLock()
+	due := tree.ttl != nil && tree.ttl.due(uint64(tree.now().UnixNano()))
+	tree.lock.RUnlock()
+	if !due {
+		return nil
+	}
+	_, err := tree.Sweep()
+	return err
+}
+
+// due tells whether a key is expired at now
+func (ttl *ttlIndex) due(now uint64) bool {
+	first, err := ttl.expiries.Min()
+	if err != nil {
+		return false
+	}
+	parts, err := tupleParts(first.(pair).Key)
+	// a malformed expiry is reported by Sweep
+	return err != nil || len(parts) != 2 || len(parts[0]) != 8 || binary.BigEndian.Uint64(parts[0]) <= now
+}
+
+// sweepChunk is how many keys Sweep deletes under one lock acquisition
+const sweepChunk = 128
+
+// Sweep deletes the keys expired when it's called in the order of their
+// deadlines, it returns the number of keys deleted. Keys are deleted in
+// chunks, other operations proceed between chunks
+func (tree *btree) Sweep() (int, error) {
+	now := uint64(tree.now().UnixNano())
+	swept := 0
+	for {
+		n, done, err := tree.sweep(now, sweepChunk)
+		swept += n
+		if err != nil || done {
+			return swept, err
+		}
+	}
+}
+
+// sweep visits at most limit expiries of keys expired at now, done is true
+// if there are no more
+func (tree *btree) sweep(now uint64, limit int) (swept int, done bool, err error) {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	if tree.ttl == nil {
+		return 0, true, nil
+	}
+	for i := 0; i < limit; i++ {
+		first, err := tree.ttl.expiries.Min()
+		if errors.Is(err, ErrNotFound) {
+			return swept, true, nil
+		} else if err != nil {
+			return swept, false, err
+		}
+		parts, err := tupleParts(first.(pair).Key)
+		if err != nil || len(parts) != 2 || len(parts[0]) != 8 {
+			return swept, false, fmt.Errorf("%w: expiry %x", ErrCorrupt, first.(pair).Key)
+		}
+		if binary.BigEndian.Uint64(parts[0]) > now {
+			return swept, true, nil
+		}
+		err = tree.del(BK(parts[1]))
+		if errors.Is(err, ErrNotFound) {
+			// the key is gone without clearing its TTL
+			err = tree.ttl.expiries.Del(first)
+		} else if err == nil {
+			swept++
+		}
+		if err != nil {
+			return swept, false, err
+		}
+	}
+	return swept, false, nil
+}
+
+// StartSweeper sweeps expired keys every interval in the background until
+// the returned stop is called
+func (tree *btree) StartSweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				tree.Sweep()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
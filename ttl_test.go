@@ -0,0 +1,178 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestTree_PutWithTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTree(32)
+	tree.clock = clock.Now
+	tree.PutWithTTL(SP("a", "1"), time.Second)
+	tree.PutWithTTL(SP("b", "1"), time.Minute)
+	tree.Put(SP("c", "1"))
+	if d, err := tree.TTL(SK("a")); err != nil || d != time.Second {
+		t.Fatalf("ttl of a should be 1s")
+	}
+	if _, err := tree.TTL(SK("c")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("c should have no ttl")
+	}
+	if _, err := tree.Get(SK("a")); err != nil {
+		t.Fatalf("a should not be expired")
+	}
+	clock.now = clock.now.Add(2 * time.Second)
+	if _, err := tree.Get(SK("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("a should be expired")
+	}
+	if tree.Len() != 2 {
+		t.Fatalf("a should be deleted lazily")
+	}
+	if ok, _ := tree.PutIfAbsent(SP("a", "2")); !ok {
+		t.Fatalf("expired key should be absent")
+	}
+	if _, err := tree.TTL(SK("a")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("put should clear the ttl")
+	}
+	// put again clears ttl
+	tree.PutWithTTL(SP("d", "1"), time.Second)
+	tree.Put(SP("d", "2"))
+	clock.now = clock.now.Add(2 * time.Second)
+	if data, err := tree.Get(SK("d")); err != nil || string(data.(pair).Val) != "2" {
+		t.Fatalf("put again should clear the ttl of d")
+	}
+}
+
+func TestTree_TTLReads(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTree(64)
+	tree.clock = clock.Now
+	tree.EnableVersions()
+	tree.PutWithTTL(SP("a", "1"), time.Second)
+	tree.Put(SP("b", "1"))
+	tree.PutWithTTL(SP("c", "1"), time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+	if min, err := tree.Min(); err != nil || string(min.(pair).Key) != "b" {
+		t.Fatalf("min should skip expired keys")
+	}
+	if max, err := tree.Max(); err != nil || string(max.(pair).Key) != "b" {
+		t.Fatalf("max should skip expired keys")
+	}
+	if tree.Len() != 1 || tree.Rank(SK("c")) != 1 || tree.Count(SK("a"), SK("z")) != 1 {
+		t.Fatalf("counts should skip expired keys")
+	}
+	if first, err := tree.Select(0); err != nil || string(first.(pair).Key) != "b" {
+		t.Fatalf("select should skip expired keys")
+	}
+	tree.PutWithTTL(SP("d", "1"), time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+	snap := tree.Snapshot()
+	defer snap.Close()
+	if snap.Len() != 1 {
+		t.Fatalf("snapshot should skip expired keys")
+	}
+	tree.PutWithTTL(SP("e", "1"), time.Second)
+	clock.now = clock.now.Add(2 * time.Second)
+	it, err := tree.IteratorAt(tree.Seq() + 1)
+	if err != nil {
+		t.Fatalf("iterator at error: %s", err.Error())
+	}
+	if keys := scanKeys(it); fmt.Sprint(keys) != "[b]" {
+		t.Fatalf("iterator at should skip expired keys, got %v", keys)
+	}
+}
+
+func TestTree_Sweep(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tree := NewTree(64)
+	tree.clock = clock.Now
+	for i := 0; i < 100; i++ {
+		tree.PutWithTTL(SP(fmt.Sprintf("%03d", i), "v"), time.Duration(i)*time.Second)
+	}
+	tree.Del(SK("010"))
+	clock.now = clock.now.Add(50 * time.Second)
+	swept, err := tree.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 000 - 050 without 010
+	if swept != 50 {
+		t.Fatalf("sweep should delete 50 keys, got %d", swept)
+	}
+	if tree.Len() != 49 || tree.ttl.expiries.Len() != 49 || tree.ttl.deadlines.Len() != 49 {
+		t.Fatalf("swept keys and their ttl should be deleted")
+	}
+	if err := tree.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTree_StartSweeper(t *testing.T) {
+	tree := NewTree(32)
+	tree.PutWithTTL(SP("a", "1"), time.Millisecond)
+	stop := tree.StartSweeper(time.Millisecond)
+	defer stop()
+	for i := 0; i < 1000 && tree.Len() > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if tree.Len() != 0 {
+		t.Fatalf("sweeper should delete a")
+	}
+}
+
+func TestTree_TTLSync(t *testing.T) {
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			clock := &fakeClock{now: time.Unix(1000, 0)}
+			tree := NewStoreTree(64, s, 0)
+			tree.clock = clock.Now
+			for i := 0; i < 300; i++ {
+				tree.PutWithTTL(SP(fmt.Sprintf("%03d", i), "v"), time.Duration(i+1)*time.Second)
+			}
+			tree.Put(SP("keep", "v"))
+			meta, err := tree.Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded.clock = clock.Now
+			if d, err := loaded.TTL(SK("099")); err != nil || d != 100*time.Second {
+				t.Fatalf("ttl of 099 should be 100s after open, got %v %v", d, err)
+			}
+			clock.now = clock.now.Add(200 * time.Second)
+			if _, err := loaded.Get(SK("150")); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("150 should be expired after open")
+			}
+			// more than a chunk of keys
+			swept, err := loaded.Sweep()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swept != 199 || loaded.Len() != 101 {
+				t.Fatalf("sweep should delete 199 keys, got %d, %d left", swept, loaded.Len())
+			}
+			if meta, err = loaded.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if loaded, err = OpenTree(s, meta); err != nil {
+				t.Fatal(err)
+			}
+			if loaded.ttl.deadlines.Len() != 100 || loaded.ttl.expiries.Len() != 100 {
+				t.Fatalf("swept deadlines should be synced")
+			}
+		})
+	})
+}
@@ -0,0 +1,314 @@
+// Package tuple encodes tuples into byte keys which sort like the tuples
+// under bytes.Compare, so composite keys can be used with inf.BP and inf.BK
+//
+//	key := tuple.MustPack("user", int64(123), "profile")
+//	tree.Put(inf.BP(key, val))
+package tuple
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// type codes, elements of different types sort by these codes
+const (
+	codeNil    = byte(0x00)
+	codeBytes  = byte(0x01)
+	codeString = byte(0x02)
+	codeNested = byte(0x05)
+	codeIntZ   = byte(0x14) // 0x0c - 0x1c, the distance to codeIntZ is the byte length
+	codeFloat  = byte(0x21)
+	codeFalse  = byte(0x26)
+	codeTrue   = byte(0x27)
+	codeTime   = byte(0x33)
+	escape     = byte(0xff)
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported tuple element")
+	ErrMalformed   = errors.New("malformed tuple")
+)
+
+// Tuple elements can be nil, []byte, string, bool, signed and unsigned
+// integers, float32, float64, time.Time and Tuple
+type Tuple []interface{}
+
+func Pack(elems ...interface{}) ([]byte, error) {
+	return Tuple(elems).Pack()
+}
+
+func MustPack(elems ...interface{}) []byte {
+	bs, err := Pack(elems...)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
+func (t Tuple) Pack() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.encode(&buf, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func Unpack(key []byte) (Tuple, error) {
+	t, rest, err := decode(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes left", ErrMalformed, len(rest))
+	}
+	return t, nil
+}
+
+func (t Tuple) encode(buf *bytes.Buffer, nested bool) error {
+	for _, e := range t {
+		switch v := e.(type) {
+		case nil:
+			buf.WriteByte(codeNil)
+			if nested {
+				buf.WriteByte(escape)
+			}
+		case []byte:
+			buf.WriteByte(codeBytes)
+			writeEscaped(buf, v)
+		case string:
+			buf.WriteByte(codeString)
+			writeEscaped(buf, []byte(v))
+		case Tuple:
+			buf.WriteByte(codeNested)
+			if err := v.encode(buf, true); err != nil {
+				return err
+			}
+			buf.WriteByte(codeNil)
+		case bool:
+			if v {
+				buf.WriteByte(codeTrue)
+			} else {
+				buf.WriteByte(codeFalse)
+			}
+		case int:
+			writeInt(buf, int64(v))
+		case int8:
+			writeInt(buf, int64(v))
+		case int16:
+			writeInt(buf, int64(v))
+		case int32:
+			writeInt(buf, int64(v))
+		case int64:
+			writeInt(buf, v)
+		case uint:
+			writeUint(buf, uint64(v))
+		case uint8:
+			writeUint(buf, uint64(v))
+		case uint16:
+			writeUint(buf, uint64(v))
+		case uint32:
+			writeUint(buf, uint64(v))
+		case uint64:
+			writeUint(buf, v)
+		case float32:
+			writeFloat(buf, float64(v))
+		case float64:
+			writeFloat(buf, v)
+		case time.Time:
+			buf.WriteByte(codeTime)
+			bs := make([]byte, 8)
+			binary.BigEndian.PutUint64(bs, uint64(v.UnixNano())^(1<<63))
+			buf.Write(bs)
+		default:
+			return fmt.Errorf("%w: %T", ErrUnsupported, e)
+		}
+	}
+	return nil
+}
+
+// writeEscaped writes v terminated by 0x00, 0x00 in v is written as 0x00 0xff
+func writeEscaped(buf *bytes.Buffer, v []byte) {
+	for _, b := range v {
+		buf.WriteByte(b)
+		if b == 0x00 {
+			buf.WriteByte(escape)
+		}
+	}
+	buf.WriteByte(0x00)
+}
+
+func writeUint(buf *bytes.Buffer, v uint64) {
+	if v == 0 {
+		buf.WriteByte(codeIntZ)
+		return
+	}
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, v)
+	n := 8 - bytesLeadingZero(bs)
+	buf.WriteByte(codeIntZ + byte(n))
+	buf.Write(bs[8-n:])
+}
+
+// writeInt writes negative integers as the one's complement of the magnitude
+// so a larger magnitude sorts first
+func writeInt(buf *bytes.Buffer, v int64) {
+	if v >= 0 {
+		writeUint(buf, uint64(v))
+		return
+	}
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(-(v+1))+1)
+	n := 8 - bytesLeadingZero(bs)
+	buf.WriteByte(codeIntZ - byte(n))
+	for _, b := range bs[8-n:] {
+		buf.WriteByte(^b)
+	}
+}
+
+// writeFloat flips the sign bit of positive numbers and all bits of negative
+// numbers, so the bytes sort like the numbers
+func writeFloat(buf *bytes.Buffer, v float64) {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	buf.WriteByte(codeFloat)
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, bits)
+	buf.Write(bs)
+}
+
+func bytesLeadingZero(bs []byte) int {
+	i := 0
+	for i < len(bs) && bs[i] == 0 {
+		i++
+	}
+	return i
+}
+
+func decode(key []byte, nested bool) (t Tuple, rest []byte, err error) {
+	t = Tuple{}
+	for len(key) > 0 {
+		code := key[0]
+		key = key[1:]
+		switch {
+		case code == codeNil:
+			if !nested {
+				t = append(t, nil)
+				continue
+			}
+			if len(key) > 0 && key[0] == escape {
+				t = append(t, nil)
+				key = key[1:]
+				continue
+			}
+			// end of the nested tuple
+			return t, key, nil
+		case code == codeBytes || code == codeString:
+			var v []byte
+			if v, key, err = readEscaped(key); err != nil {
+				return
+			}
+			if code == codeBytes {
+				t = append(t, v)
+			} else {
+				t = append(t, string(v))
+			}
+		case code == codeNested:
+			var sub Tuple
+			if sub, key, err = decode(key, true); err != nil {
+				return
+			}
+			t = append(t, sub)
+		case code >= codeIntZ-8 && code <= codeIntZ+8:
+			var v interface{}
+			if v, key, err = readInt(code, key); err != nil {
+				return
+			}
+			t = append(t, v)
+		case code == codeFloat:
+			if len(key) < 8 {
+				err = fmt.Errorf("%w: float truncated", ErrMalformed)
+				return
+			}
+			bits := binary.BigEndian.Uint64(key[:8])
+			if bits&(1<<63) != 0 {
+				bits ^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			t = append(t, math.Float64frombits(bits))
+			key = key[8:]
+		case code == codeFalse || code == codeTrue:
+			t = append(t, code == codeTrue)
+		case code == codeTime:
+			if len(key) < 8 {
+				err = fmt.Errorf("%w: time truncated", ErrMalformed)
+				return
+			}
+			nanos := int64(binary.BigEndian.Uint64(key[:8]) ^ (1 << 63))
+			t = append(t, time.Unix(0, nanos).UTC())
+			key = key[8:]
+		default:
+			err = fmt.Errorf("%w: unknown type code 0x%02x", ErrMalformed, code)
+			return
+		}
+	}
+	if nested {
+		err = fmt.Errorf("%w: nested tuple not terminated", ErrMalformed)
+		return
+	}
+	return t, nil, nil
+}
+
+func readEscaped(key []byte) (v []byte, rest []byte, err error) {
+	v = []byte{}
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0x00 {
+			v = append(v, key[i])
+			continue
+		}
+		if i+1 < len(key) && key[i+1] == escape {
+			v = append(v, 0x00)
+			i++
+			continue
+		}
+		return v, key[i+1:], nil
+	}
+	return nil, nil, fmt.Errorf("%w: bytes not terminated", ErrMalformed)
+}
+
+// readInt returns int64, or uint64 if the number doesn't fit into int64
+func readInt(code byte, key []byte) (v interface{}, rest []byte, err error) {
+	n := int(code) - int(codeIntZ)
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	if len(key) < n {
+		return nil, nil, fmt.Errorf("%w: integer truncated", ErrMalformed)
+	}
+	bs := make([]byte, 8)
+	copy(bs[8-n:], key[:n])
+	if negative {
+		for i := 8 - n; i < 8; i++ {
+			bs[i] = ^bs[i]
+		}
+	}
+	u := binary.BigEndian.Uint64(bs)
+	switch {
+	case negative:
+		v = -int64(u-1) - 1
+	case u > math.MaxInt64:
+		v = u
+	default:
+		v = int64(u)
+	}
+	return v, key[n:], nil
+}
@@ -0,0 +1,83 @@
+package tuple
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPack_roundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 123).UTC()
+	tuples := []Tuple{
+		{},
+		{nil, []byte{0, 1, 0}, "a\x00b", true, false},
+		{int64(0), int64(1), int64(-1), int64(255), int64(-256), int64(math.MaxInt64), int64(math.MinInt64), uint64(math.MaxUint64)},
+		{1.5, -0.25, math.Inf(1), math.Inf(-1)},
+		{now, Tuple{"nested", nil, Tuple{int64(3)}}, "tail"},
+	}
+	for _, tp := range tuples {
+		key, err := tp.Pack()
+		if err != nil {
+			t.Fatalf("pack %v error: %s", tp, err.Error())
+		}
+		got, err := Unpack(key)
+		if err != nil {
+			t.Fatalf("unpack %v error: %s", tp, err.Error())
+		}
+		if !reflect.DeepEqual(got, tp) {
+			t.Fatalf("round trip %v got %v", tp, got)
+		}
+	}
+}
+
+func TestPack_order(t *testing.T) {
+	ordered := []Tuple{
+		{nil},
+		{[]byte("a")},
+		{"a"},
+		{"a", int64(-1)},
+		{"a", int64(1)},
+		{"a\x00"},
+		{"ab"},
+		{Tuple{"a"}},
+		{Tuple{"a", nil}},
+		{int64(math.MinInt64)},
+		{int64(-65536)},
+		{int64(-256)},
+		{int64(-255)},
+		{int64(-1)},
+		{int64(0)},
+		{int64(1)},
+		{int64(255)},
+		{int64(256)},
+		{uint64(math.MaxUint64)},
+		{math.Inf(-1)},
+		{-2.5},
+		{-0.0},
+		{0.5},
+		{math.Inf(1)},
+		{false},
+		{true},
+		{time.Unix(-10, 0)},
+		{time.Unix(10, 0)},
+	}
+	for i := 1; i < len(ordered); i++ {
+		a, b := MustPack(ordered[i-1]...), MustPack(ordered[i]...)
+		if bytes.Compare(a, b) >= 0 {
+			t.Fatalf("%v should sort before %v", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+func TestUnpack_malformed(t *testing.T) {
+	for _, key := range [][]byte{{codeString, 'a'}, {codeIntZ + 2, 1}, {codeNested, codeTrue}, {0x99}} {
+		if _, err := Unpack(key); err == nil {
+			t.Fatalf("unpack %v should fail", key)
+		}
+	}
+	if _, err := Pack(struct{}{}); err == nil {
+		t.Fatalf("pack unsupported type should fail")
+	}
+}
@@ -0,0 +1,129 @@
+package inf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Encoding converts values of a Tree from and to bytes stored in the nodes
+type Encoding[T any] interface {
+	Encode(T) []byte
+	Decode([]byte) (T, error)
+}
+
+// Tree is a typed view of a btree, keys and values are encoded by encodings.
+// The underlying btree keeps storing pairs, so the pair API of the same tree
+// keeps working
+type Tree[K, V any] struct {
+	tree *btree
+	key  Encoding[K]
+	val  Encoding[V]
+}
+
+// KeyComparator makes the comparator name which orders keys decoded by key
+// with cmp, keys which don't decode are rejected by Check. The comparator is
+// registered so trees persisted with it are opened by OpenTree
+func KeyComparator[K any](name string, key Encoding[K], cmp func(a, b K) int) Comparator {
+	c := Comparator{
+		Name: name,
+		Compare: func(a, b []byte) int {
+			// keys are checked before they are compared, bytes order is only
+			// a fallback
+			ka, err := key.Decode(a)
+			if err != nil {
+				return bytes.Compare(a, b)
+			}
+			kb, err := key.Decode(b)
+			if err != nil {
+				return bytes.Compare(a, b)
+			}
+			return cmp(ka, kb)
+		},
+		Check: func(k []byte) error {
+			_, err := key.Decode(k)
+			return err
+		},
+	}
+	RegisterComparator(c)
+	return c
+}
+
+// Typed wraps tree into a Tree whose keys are ordered by c, c without a name
+// keeps the comparator of tree. The comparator can only be changed on an
+// empty tree
+func Typed[K, V any](tree *btree, key Encoding[K], val Encoding[V], c Comparator) (*Tree[K, V], error) {
+	t := &Tree[K, V]{tree: tree, key: key, val: val}
+	if c.Name == "" || c.Name == tree.Comparator().Name {
+		return t, nil
+	}
+	if err := tree.SetComparator(c); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewTreeOf creates a Tree on a memory btree
+func NewTreeOf[K, V any](total uint16, key Encoding[K], val Encoding[V], c Comparator) *Tree[K, V] {
+	// an empty tree always takes the comparator
+	t, _ := Typed(NewTree(total), key, val, c)
+	return t
+}
+
+func (t *Tree[K, V]) Put(key K, val V) error {
+	return t.tree.Put(BP(t.key.Encode(key), t.val.Encode(val)))
+}
+
+func (t *Tree[K, V]) Get(key K) (val V, err error) {
+	var data Storeable
+	if data, err = t.tree.Get(BK(t.key.Encode(key))); err != nil {
+		return
+	}
+	return t.val.Decode(data.(pair).Val)
+}
+
+func (t *Tree[K, V]) Del(key K) error {
+	return t.tree.Del(BK(t.key.Encode(key)))
+}
+
+// Btree returns the underlying btree
+func (t *Tree[K, V]) Btree() *btree {
+	return t.tree
+}
+
+type (
+	BytesEncoding  struct{}
+	StringEncoding struct{}
+	Uint64Encoding struct{}
+	Int64Encoding  struct{}
+)
+
+func (BytesEncoding) Encode(v []byte) []byte           { return v }
+func (BytesEncoding) Decode(bs []byte) ([]byte, error) { return bs, nil }
+
+func (StringEncoding) Encode(v string) []byte           { return []byte(v) }
+func (StringEncoding) Decode(bs []byte) (string, error) { return string(bs), nil }
+
+// Uint64Encoding encodes big endian so the bytes keep the order of the numbers
+func (Uint64Encoding) Encode(v uint64) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, v)
+	return bs
+}
+
+func (Uint64Encoding) Decode(bs []byte) (uint64, error) {
+	if len(bs) != 8 {
+		return 0, fmt.Errorf("%w: uint64 needs 8 bytes, got %d", ErrCorrupt, len(bs))
+	}
+	return binary.BigEndian.Uint64(bs), nil
+}
+
+// Int64Encoding flips the sign bit so negative numbers sort before positive ones
+func (Int64Encoding) Encode(v int64) []byte {
+	return Uint64Encoding{}.Encode(uint64(v) ^ (1 << 63))
+}
+
+func (Int64Encoding) Decode(bs []byte) (int64, error) {
+	v, err := Uint64Encoding{}.Decode(bs)
+	return int64(v ^ (1 << 63)), err
+}
@@ -0,0 +1,141 @@
+package inf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTree_typed(t *testing.T) {
+	tree := NewTreeOf[int64, string](64, Int64Encoding{}, StringEncoding{}, Comparator{})
+	for _, k := range []int64{5, -3, 100, 0, -200, 42} {
+		if err := tree.Put(k, "v"); err != nil {
+			t.Fatalf("put %d error: %s", k, err.Error())
+		}
+	}
+	tree.Put(42, "answer")
+	if v, err := tree.Get(42); err != nil || v != "answer" {
+		t.Fatalf("get 42 error")
+	}
+	if err := tree.Del(-3); err != nil {
+		t.Fatalf("del -3 error: %s", err.Error())
+	}
+	if _, err := tree.Get(-3); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get deleted key should be ErrNotFound")
+	}
+	keys := []int64{}
+	walkKeys(tree.Btree().root, func(key []byte) {
+		k, _ := Int64Encoding{}.Decode(key)
+		keys = append(keys, k)
+	})
+	expected := []int64{-200, 0, 5, 42, 100}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Fatalf("keys should be ordered numerically, got %v", keys)
+		}
+	}
+}
+
+func TestTree_typedCompare(t *testing.T) {
+	reverse := func(a, b string) int {
+		switch {
+		case a > b:
+			return -1
+		case a < b:
+			return 1
+		}
+		return 0
+	}
+	c := KeyComparator[string]("test-reverse-string", StringEncoding{}, reverse)
+	tree := NewTreeOf[string, []byte](64, StringEncoding{}, BytesEncoding{}, c)
+	for _, k := range []string{"b", "d", "a", "c", "e", "f", "g"} {
+		tree.Put(k, []byte(k))
+	}
+	keys := ""
+	walkKeys(tree.Btree().root, func(key []byte) {
+		keys += string(key)
+	})
+	if keys != "gfedcba" {
+		t.Fatalf("keys should be ordered by comparator, got %s", keys)
+	}
+	if v, err := tree.Get("c"); err != nil || string(v) != "c" {
+		t.Fatalf("get c error")
+	}
+}
+
+func walkKeys(n *node, f func(key []byte)) {
+	if n == nil {
+		return
+	}
+	walkKeys(n.first, f)
+	for _, p := range n.elems {
+		f(p.(elem).data.(pair).Key)
+		walkKeys(p.(elem).after, f)
+	}
+}
+
+func TestTree_typedGuard(t *testing.T) {
+	desc := func(a, b uint64) int {
+		switch {
+		case a > b:
+			return -1
+		case a < b:
+			return 1
+		}
+		return 0
+	}
+	c := KeyComparator[uint64]("test-desc-uint64", Uint64Encoding{}, desc)
+	tree := NewTree(64)
+	tree.Put(SP("a", "a"))
+	if _, err := Typed[uint64, string](tree, Uint64Encoding{}, StringEncoding{}, c); !errors.Is(err, ErrComparator) {
+		t.Fatalf("typed comparator on a non-empty tree should be ErrComparator, got %v", err)
+	}
+	typed := NewTreeOf[uint64, string](64, Uint64Encoding{}, StringEncoding{}, c)
+	typed.Put(1, "one")
+	if err := typed.Btree().Put(SP("bad", "v")); !errors.Is(err, ErrComparator) {
+		t.Fatalf("put a key which doesn't decode should be ErrComparator, got %v", err)
+	}
+	if _, err := typed.Btree().Get(SK("bad")); !errors.Is(err, ErrComparator) {
+		t.Fatalf("get a key which doesn't decode should be ErrComparator, got %v", err)
+	}
+	if v, err := typed.Get(1); err != nil || v != "one" {
+		t.Fatalf("get 1 error")
+	}
+}
+
+func TestTree_typedOpen(t *testing.T) {
+	desc := func(a, b int64) int {
+		return int(b - a)
+	}
+	c := KeyComparator[int64]("test-desc-int64", Int64Encoding{}, desc)
+	cleanup(func() {
+		testBlockStore(t, func(s *blockStore) {
+			typed, err := Typed[int64, string](NewStoreTree(64, s, 8), Int64Encoding{}, StringEncoding{}, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []int64{3, -1, 7} {
+				typed.Put(k, "v")
+			}
+			meta, err := typed.Btree().Sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := OpenTree(s, meta)
+			if err != nil {
+				t.Fatalf("open tree with a key comparator error: %s", err.Error())
+			}
+			if _, err := Typed[int64, string](tree, Int64Encoding{}, StringEncoding{}, c); err != nil {
+				t.Fatalf("typed on the opened tree error: %s", err.Error())
+			}
+			keys := []int64{}
+			walkKeys(tree.root, func(key []byte) {
+				k, _ := Int64Encoding{}.Decode(key)
+				keys = append(keys, k)
+			})
+			if fmt.Sprint(keys) != "[7 3 -1]" {
+				t.Fatalf("opened tree should keep the order, got %v", keys)
+			}
+		})
+	})
+}